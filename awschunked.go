@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/legal90/awscurl/pkg/awscurl"
+)
+
+// Large files are uploaded to S3 with the aws-chunked encoding, so they are streamed rather than read in memory
+// to compute the payload hash. The request is signed with the STREAMING-AWS4-HMAC-SHA256-PAYLOAD placeholder instead
+// of the hash, and its signature is the seed for the chain of signatures of the payload chunks.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+
+const (
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	// streamingChunkAlgorithm is used in the string to sign of every chunk
+	streamingChunkAlgorithm    = "AWS4-HMAC-SHA256-PAYLOAD"
+	decodedContentLengthHeader = "X-Amz-Decoded-Content-Length"
+	// streamingThreshold is the size of the smallest file streamed, the smaller ones are signed as a whole
+	streamingThreshold = 16 << 20
+	// streamingChunkSize is the size of the payload chunks, except the last ones
+	streamingChunkSize = 64 << 10
+)
+
+// streamableFile returns the regular file of the request body and its size, if it's large enough to be streamed
+func streamableFile(body io.Reader) (*os.File, int64, bool) {
+	f, ok := body.(*os.File)
+	if !ok {
+		return nil, 0, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < streamingThreshold {
+		return nil, 0, false
+	}
+	return f, info.Size(), true
+}
+
+// prepareStreamingBody sets up the request to stream the file of the given size with the aws-chunked encoding.
+// The file is reopened for the retries and the redirects.
+func prepareStreamingBody(req *http.Request, f *os.File, size int64) {
+	req.Body = f
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(f.Name())
+	}
+	req.ContentLength = awsChunkedLength(size)
+	req.Header.Set(decodedContentLengthHeader, strconv.FormatInt(size, 10))
+	// aws-chunked must be the first encoding, S3 removes it while storing the object
+	if encoding := req.Header.Get("Content-Encoding"); encoding != "" {
+		req.Header.Set("Content-Encoding", "aws-chunked,"+encoding)
+	} else {
+		req.Header.Set("Content-Encoding", "aws-chunked")
+	}
+}
+
+// awsChunkedLength returns the length of the aws-chunked body for the payload of the given size
+func awsChunkedLength(size int64) int64 {
+	length := size / streamingChunkSize * encodedChunkLength(streamingChunkSize)
+	if rest := size % streamingChunkSize; rest > 0 {
+		length += encodedChunkLength(rest)
+	}
+	return length + encodedChunkLength(0)
+}
+
+// encodedChunkLength returns the length of the chunk of n bytes: "<hex size>;chunk-signature=<signature>\r\n<data>\r\n"
+func encodedChunkLength(n int64) int64 {
+	return int64(len(strconv.FormatInt(n, 16))+len(";chunk-signature=")+64+2) + n + 2
+}
+
+// awsChunkedTransport encodes the streamed request body with aws-chunked, signing every chunk. Every attempt
+// (including the retries and the redirects) is signed again before it's sent, so the chunks are always signed
+// starting from the seed signature of the attempt itself.
+type awsChunkedTransport struct {
+	next  http.RoundTripper
+	creds aws.Credentials
+}
+
+func (t *awsChunkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(contentSHA256Header) != streamingPayload || req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	seed, scope, err := parseSeedSignature(req.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	signingTime, err := time.Parse(amzDateFormat, req.Header.Get(amzDateHeader))
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to sign the payload chunks: invalid %s header", amzDateHeader)
+	}
+	scopeParts := strings.Split(scope, "/")
+	if len(scopeParts) != 4 {
+		return nil, fmt.Errorf("Error: Unable to sign the payload chunks: invalid credential scope %s", scope)
+	}
+
+	encoded := req.Clone(req.Context())
+	encoded.Body = &awsChunkedReader{
+		body:      req.Body,
+		key:       awscurl.DeriveSigningKey(t.creds.SecretAccessKey, signingTime, scopeParts[1], scopeParts[2]),
+		timestamp: signingTime.UTC().Format(amzDateFormat),
+		scope:     scope,
+		signature: seed,
+		chunk:     make([]byte, streamingChunkSize),
+	}
+	return t.next.RoundTrip(encoded)
+}
+
+// parseSeedSignature returns the signature and the credential scope from the Authorization header of the signed request
+func parseSeedSignature(authorization string) (string, string, error) {
+	var signature, scope string
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, awscurl.SigningAlgorithm+" "), ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.HasPrefix(part, "Signature="):
+			signature = strings.TrimPrefix(part, "Signature=")
+		case strings.HasPrefix(part, "Credential="):
+			if i := strings.Index(part, "/"); i >= 0 {
+				scope = part[i+1:]
+			}
+		}
+	}
+	if signature == "" || scope == "" {
+		return "", "", fmt.Errorf("Error: Unable to sign the payload chunks: the request isn't signed with %s", awscurl.SigningAlgorithm)
+	}
+	return signature, scope, nil
+}
+
+// awsChunkedReader reads the body encoded with aws-chunked, the signature of every chunk covers the previous one
+type awsChunkedReader struct {
+	body      io.ReadCloser
+	key       []byte
+	timestamp string
+	scope     string
+	// signature is the signature of the previous chunk, or the seed one for the first chunk
+	signature string
+	chunk     []byte
+	encoded   bytes.Buffer
+	done      bool
+}
+
+func (r *awsChunkedReader) Read(p []byte) (int, error) {
+	for r.encoded.Len() == 0 && !r.done {
+		n, err := io.ReadFull(r.body, r.chunk)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		// The empty chunk ends the body
+		r.writeChunk(r.chunk[:n])
+		r.done = n == 0
+	}
+	if r.encoded.Len() == 0 {
+		return 0, io.EOF
+	}
+	return r.encoded.Read(p)
+}
+
+// writeChunk signs the chunk and writes it to the encoded buffer
+func (r *awsChunkedReader) writeChunk(data []byte) {
+	stringToSign := strings.Join([]string{streamingChunkAlgorithm, r.timestamp, r.scope, r.signature, hashSHA256(nil), hashSHA256(data)}, "\n")
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(stringToSign))
+	r.signature = hex.EncodeToString(mac.Sum(nil))
+
+	fmt.Fprintf(&r.encoded, "%x;chunk-signature=%s\r\n", len(data), r.signature)
+	r.encoded.Write(data)
+	r.encoded.WriteString("\r\n")
+}
+
+func (r *awsChunkedReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// awsError contains the error details extracted from an AWS error response
+type awsError struct {
+	Code    string
+	Message string
+}
+
+func (e awsError) String() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// parseAWSError extracts the error code and message from the AWS error response.
+// Both JSON (`{"__type": "...", "message": "..."}`) and XML (`<Error><Code>...</Code>...</Error>`) envelopes
+// are supported. The second returned value is false if the body doesn't look like an AWS error.
+func parseAWSError(header http.Header, body []byte) (awsError, bool) {
+	var e awsError
+
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		e = parseJSONError(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		e = parseXMLError(trimmed)
+	}
+
+	// Some services (e.g. API Gateway, REST-JSON protocols) return the error code only in the header
+	if e.Code == "" {
+		e.Code = header.Get("X-Amzn-Errortype")
+	}
+
+	// Strip the namespace and the documentation URL, if any:
+	// "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException" or "ValidationException:http://internal.amazon.com/..."
+	if i := strings.LastIndex(e.Code, "#"); i >= 0 {
+		e.Code = e.Code[i+1:]
+	}
+	if i := strings.Index(e.Code, ":"); i >= 0 {
+		e.Code = e.Code[:i]
+	}
+
+	return e, e.Code != ""
+}
+
+func parseJSONError(body []byte) awsError {
+	var v map[string]interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return awsError{}
+	}
+
+	// Keys differ between services and protocols, so we pick the first one present
+	return awsError{
+		Code:    firstJSONString(v, "__type", "code", "Code", "errorType"),
+		Message: firstJSONString(v, "message", "Message", "errorMessage"),
+	}
+}
+
+func firstJSONString(v map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := v[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// parseXMLError looks for the first <Code> and <Message> elements in the document.
+// This covers S3 (<Error>), query protocol (<ErrorResponse><Error>) and EC2 (<Response><Errors><Error>) formats.
+func parseXMLError(body []byte) awsError {
+	var e awsError
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for e.Code == "" || e.Message == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var target *string
+		switch start.Name.Local {
+		case "Code":
+			target = &e.Code
+		case "Message":
+			target = &e.Message
+		default:
+			continue
+		}
+
+		var value string
+		if err := decoder.DecodeElement(&value, &start); err != nil {
+			break
+		}
+		if *target == "" {
+			*target = strings.TrimSpace(value)
+		}
+	}
+
+	return e
+}
+
+// requestIDHeaders are the response headers AWS services use to return the request ID
+var requestIDHeaders = []string{"X-Amzn-Requestid", "X-Amz-Request-Id", "X-Amzn-Request-Id"}
+
+// requestID returns the AWS request ID from the response headers, if any
+func requestID(header http.Header) string {
+	for _, h := range requestIDHeaders {
+		if id := header.Get(h); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// statusError is returned when the server responds with an unsuccessful HTTP status.
+// The process exit code is derived from the status class, see exitCode().
+type statusError struct {
+	Status     string
+	StatusCode int
+	RequestID  string
+}
+
+func (e *statusError) Error() string {
+	msg := fmt.Sprintf("Error: The server responded with %s", e.Status)
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(", request ID: %s", e.RequestID)
+	}
+	return msg
+}
+
+// exitCode maps the HTTP status to the process exit code: 3 for 3xx, 4 for 4xx, 5 for 5xx and 1 for anything else
+func (e *statusError) exitCode() int {
+	switch class := e.StatusCode / 100; class {
+	case 3, 4, 5:
+		return class
+	default:
+		return 1
+	}
+}
+
+func newStatusError(response *http.Response) *statusError {
+	return &statusError{
+		Status:     response.Status,
+		StatusCode: response.StatusCode,
+		RequestID:  requestID(response.Header),
+	}
+}
+
+// exitCoder is implemented by the errors which define their own process exit code
+type exitCoder interface {
+	exitCode() int
+}
+
+const exitCodeAuthError = 6
+
+// signatureErrorCodes are the AWS error codes meaning the signature or the credentials themselves are rejected,
+// as opposed to the valid credentials lacking permissions for the request
+var signatureErrorCodes = map[string]bool{
+	"SignatureDoesNotMatch":               true,
+	"InvalidSignatureException":           true,
+	"IncompleteSignature":                 true,
+	"IncompleteSignatureException":        true,
+	"MissingAuthenticationToken":          true,
+	"MissingAuthenticationTokenException": true,
+	"InvalidAccessKeyId":                  true,
+	"InvalidClientTokenId":                true,
+	"UnrecognizedClientException":         true,
+	"AuthFailure":                         true,
+	"ExpiredToken":                        true,
+	"ExpiredTokenException":               true,
+	"RequestExpired":                      true,
+	"RequestTimeTooSkewed":                true,
+	"InvalidToken":                        true,
+}
+
+// authError is returned by --abort-on-auth-error when the server responds with 401 or 403.
+// It tells the rejected signature or credentials apart from the missing permissions, if the AWS error code allows to.
+type authError struct {
+	*statusError
+	AWSError awsError
+}
+
+func newAuthError(response *http.Response, body []byte) *authError {
+	awsErr, _ := parseAWSError(response.Header, body)
+	return &authError{statusError: newStatusError(response), AWSError: awsErr}
+}
+
+func (e *authError) Error() string {
+	var reason string
+	switch {
+	case signatureErrorCodes[e.AWSError.Code]:
+		reason = "the signature or the credentials are invalid"
+	case e.AWSError.Code != "" && e.StatusCode == http.StatusForbidden:
+		reason = "the credentials lack the permissions"
+	default:
+		reason = "the request is not authorized"
+	}
+
+	msg := fmt.Sprintf("Error: The server responded with %s: %s", e.Status, reason)
+	if e.AWSError.Code != "" {
+		msg += fmt.Sprintf(" (%s)", e.AWSError)
+	}
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(", request ID: %s", e.RequestID)
+	}
+	return msg
+}
+
+func (e *authError) exitCode() int {
+	return exitCodeAuthError
+}
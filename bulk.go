@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	urls "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+)
+
+// bulkCmd sends many signed requests concurrently, resolving the credentials only once
+var bulkCmd = &cobra.Command{
+	Use:   "bulk [flags] FILE",
+	Short: "Sign and send the requests listed in a file concurrently",
+	Long: `Sign and send the requests listed in the file (or stdin for "-"), one per line: either a URL to GET,
+or a JSON object like {"method": "POST", "url": "...", "headers": {"Content-Type": "application/json"}, "body": "..."}.
+Blank lines and lines starting with "#" are skipped.
+
+The credentials are resolved once and shared by all the requests, refreshed only when they expire, while every
+request is signed on its own, for the service and the region detected from its URL, unless --service and --region
+(or --aws-sigv4) are given, with the profile mapped to its host with --host-profile-map.
+The headers given with -H are added to every request, and --retry applies to every request the same way as to
+a single one.
+
+The result of every request is printed as a JSON line in the order of the file, e.g.
+{"line": 1, "method": "GET", "url": "...", "status_code": 200, "elapsed_ms": 42.5}.
+The exit code is 1 if any request fails to be sent or gets a response with the status of 400 or above.
+`,
+	Args:    cobra.ExactArgs(1),
+	PreRunE: loadConfig,
+	RunE:    runBulk,
+}
+
+var bulkParallel int
+
+func init() {
+	bulkCmd.Flags().IntVar(&bulkParallel, "parallel", 4, "Number of the requests sent at the same time")
+}
+
+// bulkRequest is a single request of the bulk file
+type bulkRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// bulkResult is printed for every request of the bulk file
+type bulkResult struct {
+	Line       int     `json:"line"`
+	Method     string  `json:"method"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	ElapsedMs  float64 `json:"elapsed_ms"`
+}
+
+// bulkTask is a parsed request along with its line in the file
+type bulkTask struct {
+	line int
+	req  bulkRequest
+}
+
+func runBulk(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	if flags.silent {
+		diagnostics = ioutil.Discard
+	}
+	if bulkParallel < 1 {
+		return fmt.Errorf("Error: Invalid --parallel value: %d. It should be at least 1", bulkParallel)
+	}
+
+	tasks, err := readBulkFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	// The headers and the signing flags are the same for all the requests, so they are checked before any of them is sent
+	headers, err := parseHeaders(flags.headers)
+	if err != nil {
+		return err
+	}
+	sigV4Region, sigV4Service, err := parseAWSSigV4(flags.awsSigV4)
+	if err != nil {
+		return err
+	}
+	signingDate, err := parseSigningDate(flags.date)
+	if err != nil {
+		return err
+	}
+	if _, err := newAWSClient(aws.Config{}, flags.awsService, signingDate); err != nil {
+		return err
+	}
+
+	// Every request gets the config of its host, e.g. of the profile given with --host-profile-map. The configs are
+	// loaded once per profile and cache their credentials, so e.g. the role is assumed once for all the requests.
+	configs, err := newAWSConfigs(flags)
+	if err != nil {
+		return err
+	}
+	cfgs := make([]aws.Config, len(tasks))
+	for i, task := range tasks {
+		var host string
+		if u, err := urls.Parse(task.req.URL); err == nil {
+			host = u.Hostname()
+		}
+		if cfgs[i], err = configs.forHost(host); err != nil {
+			return err
+		}
+		// The credentials are retrieved upfront to fail early, before any request is sent
+		if _, err := retrieveCredentials(cfgs[i]); err != nil {
+			return err
+		}
+	}
+
+	// The requests are sent the same way as the single one, e.g. through the same proxy and with the same certificates
+	tr, transport, err := newTransport(cmd)
+	if err != nil {
+		return err
+	}
+	tr.MaxIdleConnsPerHost = bulkParallel
+	sender := &bulkSender{
+		cmd:          cmd,
+		client:       &http.Client{Transport: transport, Timeout: flags.maxTime},
+		headers:      headers,
+		signingDate:  signingDate,
+		sigV4Service: sigV4Service,
+		sigV4Region:  sigV4Region,
+		retrier:      newRetrier(),
+	}
+
+	results := make([]bulkResult, len(tasks))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < bulkParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = sender.send(cfgs[i], tasks[i])
+			}
+		}()
+	}
+	for i := range tasks {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	failed := 0
+	encoder := json.NewEncoder(os.Stdout)
+	for _, result := range results {
+		if result.Error != "" || result.StatusCode >= 400 {
+			failed++
+		}
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(diagnostics, "%d requests sent, %d failed\n", len(results), failed)
+
+	if failed > 0 {
+		// Every failure is already reported in its result line
+		cmd.SilenceErrors = true
+		return fmt.Errorf("Error: %d of %d requests failed", failed, len(results))
+	}
+	return nil
+}
+
+// readBulkFile reads the requests from the file, or from stdin for "-"
+func readBulkFile(path string) ([]bulkTask, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var tasks []bulkTask
+	scanner := bufio.NewScanner(r)
+	// A single JSON line could carry a large body, e.g. for OpenSearch bulk indexing
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		req := bulkRequest{URL: text}
+		if strings.HasPrefix(text, "{") {
+			req = bulkRequest{}
+			if err := json.Unmarshal([]byte(text), &req); err != nil {
+				return nil, fmt.Errorf("Error: Invalid request at the line %d of %s: %s", line, path, err)
+			}
+		}
+		if req.URL == "" {
+			return nil, fmt.Errorf("Error: Invalid request at the line %d of %s: the URL is missing", line, path)
+		}
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+		method, err := normalizeMethod(req.Method)
+		if err != nil {
+			return nil, fmt.Errorf("Error: Invalid request at the line %d of %s: %s", line, path, strings.TrimPrefix(err.Error(), "Error: "))
+		}
+		req.Method = method
+		tasks = append(tasks, bulkTask{line: line, req: req})
+	}
+	return tasks, scanner.Err()
+}
+
+// bulkSender sends the requests of the bulk file with the options shared by all of them
+type bulkSender struct {
+	cmd                       *cobra.Command
+	client                    *http.Client
+	headers                   http.Header
+	signingDate               time.Time
+	sigV4Service, sigV4Region string
+	retrier                   *retrier
+}
+
+// send signs and sends the single request with the config of its host, the response body is read and discarded
+func (s *bulkSender) send(cfg aws.Config, task bulkTask) (result bulkResult) {
+	result = bulkResult{Line: task.line, Method: task.req.Method, URL: task.req.URL}
+	start := time.Now()
+	defer func() { result.ElapsedMs = milliseconds(time.Since(start)) }()
+
+	var body io.Reader = http.NoBody
+	if task.req.Body != "" {
+		body = strings.NewReader(task.req.Body)
+	}
+	req, err := http.NewRequest(task.req.Method, task.req.URL, body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	addHeaders(req.Header, s.headers)
+	for name, value := range task.req.Headers {
+		req.Header.Set(name, value)
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		userAgent := "awscurl/" + version
+		if flagGiven(s.cmd, "user-agent") {
+			userAgent = flags.userAgent
+		}
+		req.Header["User-Agent"] = []string{userAgent}
+	}
+
+	if flags.requestPayer {
+		req.Header.Set(requestPayerHeader, "requester")
+	}
+
+	// The same precedence as for a single request
+	service, region := resolveService(s.cmd, req.URL.Hostname(), s.sigV4Service, s.sigV4Region)
+	if region != "" {
+		cfg.Region = region
+	}
+	if known, ok := serviceByName(service); ok && known.ContentType != "" && task.req.Body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", known.ContentType)
+	}
+	signingName := service
+	if flags.signingName != "" {
+		signingName = flags.signingName
+	}
+
+	signer, _, payloadHash, err := newRequestSigner(cfg, signingName, s.signingDate, req, []byte(task.req.Body), false)
+	if err != nil {
+		result.Error = strings.TrimPrefix(err.Error(), "Error: ")
+		return result
+	}
+	if _, err := signer.Sign(req, payloadHash); err != nil {
+		result.Error = strings.TrimPrefix(err.Error(), "Error: ")
+		return result
+	}
+
+	// Every retry is signed again, the same as for a single request
+	response, err := s.retrier.do(s.client, req, payloadHash, signer, &requestTimings{})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer response.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, response.Body); err != nil {
+		result.Error = err.Error()
+	}
+	result.StatusCode = response.StatusCode
+	return result
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestBulkTransportAndSigningFlags(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	serverURL, _ := url.Parse(server.URL)
+
+	// The hosts of the file don't exist, the requests get to the server only with --connect-to
+	file := filepath.Join(t.TempDir(), "requests.txt")
+	content := "http://api.example.test/items\n" +
+		`{"method": "POST", "url": "http://api.example.test/items", "body": "{}"}` + "\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runAwscurl(t, "bulk", "--connect-to", "api.example.test:80:"+serverURL.Host,
+		"--date", "2024-01-02T15:04:05Z", "--request-payer", "--region", "eu-west-1", file)
+	if err != nil {
+		t.Fatalf("awscurl bulk failed: %s\n%s", err, output)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("awscurl bulk printed %d results, want 2:\n%s", len(lines), output)
+	}
+	for _, line := range lines {
+		var result bulkResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.StatusCode != 200 || result.Error != "" {
+			t.Errorf("line %d: status %d, error %q", result.Line, result.StatusCode, result.Error)
+		}
+	}
+
+	for _, received := range server.requests {
+		if received.signatureErr != "" {
+			t.Errorf("%s %s: %s", received.Method, received.URL, received.signatureErr)
+		}
+		if date := received.Header.Get(amzDateHeader); date != "20240102T150405Z" {
+			t.Errorf("%s = %q, want the --date value", amzDateHeader, date)
+		}
+		if payer := received.Header.Get(requestPayerHeader); payer != "requester" {
+			t.Errorf("%s = %q, want %q", requestPayerHeader, payer, "requester")
+		}
+		if authorization := received.Header.Get("Authorization"); !strings.Contains(authorization, "/eu-west-1/execute-api/") ||
+			!strings.Contains(authorization, "x-amz-request-payer") {
+			t.Errorf("Authorization = %q, want the signature of execute-api in eu-west-1 with %s", authorization, requestPayerHeader)
+		}
+	}
+}
+
+func TestBulkRegionSetRequiresSigV4A(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "requests.txt")
+	if err := ioutil.WriteFile(file, []byte("http://api.example.test/items\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := runAwscurl(t, "bulk", "--region-set", "*", file)
+	if err == nil || !strings.Contains(err.Error(), "--region-set could be used only together with --sigv4a") {
+		t.Errorf("awscurl bulk error = %v, want the --region-set error", err)
+	}
+}
+
+func TestBulkRequestFlags(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	failed := false
+	server.handler = func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && !failed {
+			failed = true
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	}
+
+	dir := t.TempDir()
+	headersFile := filepath.Join(dir, "headers.txt")
+	if err := ioutil.WriteFile(headersFile, []byte("X-From-File: file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "requests.txt")
+	if err := ioutil.WriteFile(file, []byte(server.URL+"/flaky\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runAwscurl(t, "bulk", "-H", "X-One: 1\nX-Two: 2", "-H", "@"+headersFile, "--retry", "1",
+		"--aws-sigv4", "aws:amz:us-west-2:lambda", file)
+	if err != nil {
+		t.Fatalf("awscurl bulk failed: %s\n%s", err, output)
+	}
+	var result bulkResult
+	if err := json.Unmarshal([]byte(strings.TrimSpace(output)), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != 200 {
+		t.Errorf("status %d, want 200 after the retry: %s", result.StatusCode, output)
+	}
+
+	if len(server.requests) != 2 {
+		t.Fatalf("server got %d requests, want 2 with the retry", len(server.requests))
+	}
+	for _, received := range server.requests {
+		if received.signatureErr != "" {
+			t.Errorf("%s %s: %s", received.Method, received.URL, received.signatureErr)
+		}
+		for name, value := range map[string]string{"X-One": "1", "X-Two": "2", "X-From-File": "file"} {
+			if got := received.Header.Get(name); got != value {
+				t.Errorf("%s = %q, want %q", name, got, value)
+			}
+		}
+		if authorization := received.Header.Get("Authorization"); !strings.Contains(authorization, "/us-west-2/lambda/") {
+			t.Errorf("Authorization = %q, want the signature of lambda in us-west-2", authorization)
+		}
+	}
+}
+
+func TestBulkHostProfileMap(t *testing.T) {
+	otherCredentials := aws.Credentials{AccessKeyID: "AKIDOTHER", SecretAccessKey: "other-secret"}
+	defaultServer := newSigV4Verifier(t, testCredentials)
+	otherServer := newSigV4Verifier(t, otherCredentials)
+
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	content := "[other]\naws_access_key_id = AKIDOTHER\naws_secret_access_key = other-secret\n"
+	if err := ioutil.WriteFile(credentialsFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The hosts of the file don't exist, the requests get to the servers only with --connect-to
+	file := filepath.Join(dir, "requests.txt")
+	if err := ioutil.WriteFile(file, []byte("http://default.example.test/\nhttp://other.example.test/\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := runAwscurlEnv(t, map[string]string{"AWS_SHARED_CREDENTIALS_FILE": credentialsFile}, "bulk",
+		"--connect-to", "default.example.test:80:"+mustParseURL(t, defaultServer.URL).Host,
+		"--connect-to", "other.example.test:80:"+mustParseURL(t, otherServer.URL).Host,
+		"--host-profile-map", "other.example.test=other", "--service", "execute-api", file)
+	if err != nil {
+		t.Fatalf("awscurl bulk failed: %s\n%s", err, output)
+	}
+
+	for name, server := range map[string]*sigV4Verifier{"default": defaultServer, "other": otherServer} {
+		if len(server.requests) != 1 {
+			t.Fatalf("%s server got %d requests, want 1:\n%s", name, len(server.requests), output)
+		}
+		if err := server.requests[0].signatureErr; err != "" {
+			t.Errorf("%s server: %s", name, err)
+		}
+	}
+}
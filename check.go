@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// runCheck sends the request in the --check mode. Instead of the response body, it prints a single line:
+// "OK <status> <elapsed>" for a 2xx response, or "FAIL <reason>" for any other response or error.
+// Any failure exits with 1, regardless of the HTTP status.
+func runCheck(cmd *cobra.Command, args []string) error {
+	// The error is reported as the FAIL line instead
+	cmd.SilenceErrors = true
+
+	err := runCurl(cmd, args)
+	if err != nil {
+		fmt.Printf("FAIL %s\n", strings.TrimPrefix(err.Error(), "Error: "))
+	}
+	return err
+}
+
+// checkResponse prints the OK line for a successful response, or returns the failure reason
+func checkResponse(response *http.Response, content []byte, timings *requestTimings) error {
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		if awsErr, ok := parseAWSError(response.Header, content); ok {
+			return fmt.Errorf("%s (%s)", response.Status, awsErr)
+		}
+		return fmt.Errorf("%s", response.Status)
+	}
+
+	fmt.Printf("OK %d %s\n", response.StatusCode, timings.total().Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// knownRegions are completed for --region. Any other region could be passed as well.
+var knownRegions = []string{
+	"af-south-1", "ap-east-1", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3", "ap-south-1", "ap-south-2",
+	"ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-4", "ca-central-1", "ca-west-1",
+	"cn-north-1", "cn-northwest-1", "eu-central-1", "eu-central-2", "eu-north-1", "eu-south-1", "eu-south-2",
+	"eu-west-1", "eu-west-2", "eu-west-3", "il-central-1", "me-central-1", "me-south-1", "sa-east-1",
+	"us-east-1", "us-east-2", "us-gov-east-1", "us-gov-west-1", "us-west-1", "us-west-2",
+}
+
+// registerCompletions registers the completion of the flag values with a known set of values.
+// The shell completion script itself is generated by the default "completion" command of cobra, e.g. "awscurl completion zsh".
+// It's called once the flags are defined.
+func registerCompletions() {
+	completeValues := func(values []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			var matching []string
+			for _, v := range values {
+				if strings.HasPrefix(v, toComplete) {
+					matching = append(matching, v)
+				}
+			}
+			return matching, cobra.ShellCompDirectiveNoFileComp
+		}
+	}
+
+	var services []string
+	for _, s := range knownServices {
+		services = append(services, s.Name+"\t"+s.Description)
+	}
+
+	rootCmd.RegisterFlagCompletionFunc("service", completeValues(services))
+	rootCmd.RegisterFlagCompletionFunc("signing-name", completeValues(services))
+	rootCmd.RegisterFlagCompletionFunc("region", completeValues(knownRegions))
+	rootCmd.RegisterFlagCompletionFunc("snippet", completeValues(snippetFormatNames()))
+
+	// The URL can't be completed, and it's not a file either
+	rootCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is the config file read when --config is not given, relative to the home directory
+var defaultConfigFile = filepath.Join(".awscurl", "config")
+
+// configEnv are the environment variables taking precedence over the config file keys, the same as in the AWS CLI
+var configEnv = map[string][]string{
+	"profile": {"AWS_PROFILE"},
+	"region":  {"AWS_REGION", "AWS_DEFAULT_REGION"},
+}
+
+// configFlags are the flags set from the config file. They aren't marked as changed, so the values given in the
+// environment or detected from the URL host take precedence over them, the same as over the built-in defaults.
+var configFlags = map[string]bool{}
+
+// flagGiven tells whether the flag is given in the command line or in the config file
+func flagGiven(cmd *cobra.Command, name string) bool {
+	return cmd.Flags().Changed(name) || configFlags[name]
+}
+
+// loadConfig loads the config file for the commands sending the requests or resolving the credentials, i.e. the root
+// command, exec and bulk. The other ones (e.g. completion and services) don't read it, so a broken file doesn't break them.
+func loadConfig(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	return loadConfigFile(cmd, flags.config)
+}
+
+// loadConfigFile sets the defaults of the flags from the YAML config file, where the keys are the long flag names:
+//
+//	service: es
+//	region: eu-west-1
+//	header:
+//	  - "Accept: application/json"
+//
+// The flags given in the command line take precedence, then the environment variables and the values detected from the URL
+// host (e.g. the service and the region), then the config file. The default file is optional, while the one given with
+// --config must exist.
+func loadConfigFile(cmd *cobra.Command, path string) error {
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, defaultConfigFile)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error: Unable to read the config file: %s", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("Error: Unable to parse the config file %s: %s", path, err)
+	}
+
+	// The keys are applied in a fixed order, so the errors are deterministic
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || name == "config" || name == "help" || name == "version" {
+			return fmt.Errorf("Error: Unknown option %q in the config file %s", name, path)
+		}
+		if flag.Changed || envSet(configEnv[name]) {
+			continue
+		}
+		if err := setFlagFromConfig(flag, values[name]); err != nil {
+			return fmt.Errorf("Error: Invalid value of %q in the config file %s: %s", name, path, err)
+		}
+		configFlags[name] = true
+	}
+	return nil
+}
+
+// envSet tells whether any of the environment variables is set
+func envSet(names []string) bool {
+	for _, name := range names {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// setFlagFromConfig sets the flag to the config value. A list sets the flag once per item, as if it's repeated.
+// Unlike pflag.FlagSet.Set, the flag isn't marked as changed.
+func setFlagFromConfig(flag *pflag.Flag, value interface{}) error {
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return fmt.Errorf("the value is empty")
+	case map[string]interface{}:
+		return fmt.Errorf("nested options are not supported")
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("nested options are not supported")
+		}
+		if err := flag.Value.Set(fmt.Sprint(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
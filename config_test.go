@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newConfigTestCommand returns the command with a few flags of different types, parsed from the given args
+func newConfigTestCommand(t *testing.T, args ...string) (*cobra.Command, *awsCURLFlags) {
+	t.Helper()
+	f := &awsCURLFlags{}
+	cmd := &cobra.Command{Use: "awscurl"}
+	cmd.Flags().StringVar(&f.awsService, "service", "execute-api", "")
+	cmd.Flags().StringVar(&f.awsRegion, "region", "", "")
+	cmd.Flags().StringVar(&f.awsProfile, "profile", "", "")
+	cmd.Flags().StringArrayVarP(&f.headers, "header", "H", []string{}, "")
+	cmd.Flags().BoolVarP(&f.include, "include", "i", false, "")
+	cmd.Flags().StringVar(&f.config, "config", "", "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd, f
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		content string
+		env     map[string]string
+		want    awsCURLFlags
+		fromCfg []string
+		wantErr string
+	}{
+		{
+			name:    "values",
+			content: "service: es\nregion: eu-west-1\ninclude: true\n",
+			want:    awsCURLFlags{awsService: "es", awsRegion: "eu-west-1", include: true, headers: []string{}},
+			fromCfg: []string{"include", "region", "service"},
+		},
+		{
+			name:    "list repeats the flag",
+			content: "header:\n  - \"Accept: application/json\"\n  - \"X-Foo: bar\"\n",
+			want:    awsCURLFlags{awsService: "execute-api", headers: []string{"Accept: application/json", "X-Foo: bar"}},
+			fromCfg: []string{"header"},
+		},
+		{
+			name:    "command line wins",
+			args:    []string{"--service", "s3", "-H", "X-Foo: cli"},
+			content: "service: es\nheader: \"X-Foo: config\"\n",
+			want:    awsCURLFlags{awsService: "s3", headers: []string{"X-Foo: cli"}},
+		},
+		{
+			// The same as in the AWS CLI, the environment wins over the config file
+			name:    "environment wins",
+			content: "service: es\nprofile: dev\nregion: eu-west-1\n",
+			env:     map[string]string{"AWS_PROFILE": "env", "AWS_DEFAULT_REGION": "us-west-2"},
+			want:    awsCURLFlags{awsService: "es", headers: []string{}},
+			fromCfg: []string{"service"},
+		},
+		{
+			name:    "profile",
+			content: "profile: dev\n",
+			want:    awsCURLFlags{awsService: "execute-api", awsProfile: "dev", headers: []string{}},
+			fromCfg: []string{"profile"},
+		},
+		{
+			name:    "unknown option",
+			content: "servce: es\n",
+			wantErr: `Unknown option "servce"`,
+		},
+		{
+			name:    "nested option",
+			content: "header:\n  name: value\n",
+			wantErr: "nested options are not supported",
+		},
+		{
+			name:    "invalid value",
+			content: "include: maybe\n",
+			wantErr: `Invalid value of "include"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFlags = map[string]bool{}
+			defer func() { configFlags = map[string]bool{} }()
+			for _, name := range []string{"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			path := filepath.Join(t.TempDir(), "config")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cmd, f := newConfigTestCommand(t, tt.args...)
+
+			err := loadConfigFile(cmd, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfigFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := awsCURLFlags{awsService: f.awsService, awsRegion: f.awsRegion, awsProfile: f.awsProfile, headers: f.headers, include: f.include}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flags = %+v, want %+v", got, tt.want)
+			}
+			for _, name := range tt.fromCfg {
+				// The config values must not look as given in the command line, so the detected values win over them
+				if cmd.Flags().Changed(name) {
+					t.Errorf("flag %q is marked as changed", name)
+				}
+				if !flagGiven(cmd, name) {
+					t.Errorf("flagGiven(%q) = false, want true", name)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	cmd, _ := newConfigTestCommand(t)
+	if err := loadConfigFile(cmd, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("loadConfigFile() succeeded for the missing --config file")
+	}
+}
+
+func TestConfigFileCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := ioutil.WriteFile(path, []byte("servce: es\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the commands sending the requests read the config file, so the broken one doesn't break the others
+	if _, err := runAwscurl(t, "services", "--config", path); err != nil {
+		t.Errorf("awscurl services error = %v", err)
+	}
+	for _, args := range [][]string{{"http://127.0.0.1:1/"}, {"exec", "true"}, {"bulk", "-"}} {
+		if _, err := runAwscurl(t, append([]string{"--config", path}, args...)...); err == nil || !strings.Contains(err.Error(), `Unknown option "servce"`) {
+			t.Errorf("awscurl %s error = %v, want the config file error", strings.Join(args, " "), err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	urls "net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// netscapeCookieHeader starts the cookie files written with --cookie-jar, the same as the ones written by curl
+const netscapeCookieHeader = "# Netscape HTTP Cookie File\n# This file was generated by awscurl. Edit at your own risk.\n\n"
+
+// cookieEntry is a single cookie as stored in the Netscape cookie file
+type cookieEntry struct {
+	domain string
+	// hostOnly cookies are sent to the exact domain only, not to its subdomains
+	hostOnly bool
+	path     string
+	secure   bool
+	httpOnly bool
+	// expires is zero for the session cookies
+	expires time.Time
+	name    string
+	value   string
+}
+
+// cookieJar keeps the cookies read with -b/--cookie and received from the server. The matching cookies are
+// picked by net/http/cookiejar, while the entries are kept to be written to the --cookie-jar file.
+type cookieJar struct {
+	jar     *cookiejar.Jar
+	entries []cookieEntry
+	// literal are the "NAME=VALUE" cookies given with -b, they are sent with every request and never saved
+	literal []string
+	// explicit is the Cookie header given with -H
+	explicit string
+}
+
+func newCookieJar() *cookieJar {
+	// Without the public suffix list, the cookies are never set for the domains like "amazonaws.com" as a whole
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{jar: jar}
+}
+
+// add parses the --cookie value, as curl does: either "NAME=VALUE; NAME2=VALUE2", or the Netscape cookie file
+func (j *cookieJar) add(value string) error {
+	if !strings.Contains(value, "=") {
+		return j.load(value)
+	}
+
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return fmt.Errorf(`Error: Invalid cookie: %s. It should be in the format "NAME=VALUE"`, pair)
+		}
+		j.literal = append(j.literal, name+"="+strings.TrimSpace(parts[1]))
+	}
+	return nil
+}
+
+// load reads the cookies from the Netscape cookie file, as written by curl or --cookie-jar
+func (j *cookieJar) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("Error: Unable to read the cookie file: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		entry := cookieEntry{}
+		if strings.HasPrefix(text, "#HttpOnly_") {
+			text, entry.httpOnly = strings.TrimPrefix(text, "#HttpOnly_"), true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("Error: Invalid cookie at the line %d of %s: 7 tab-separated fields are expected, %d given", line, file, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Error: Invalid cookie at the line %d of %s: invalid expiration time %q", line, file, fields[4])
+		}
+		if expires > 0 {
+			entry.expires = time.Unix(expires, 0)
+		}
+		entry.domain = strings.ToLower(strings.TrimPrefix(fields[0], "."))
+		entry.hostOnly = !strings.EqualFold(fields[1], "TRUE")
+		entry.path = fields[2]
+		entry.secure = strings.EqualFold(fields[3], "TRUE")
+		entry.name, entry.value = fields[5], fields[6]
+		j.put(entry)
+	}
+	return scanner.Err()
+}
+
+// put adds the entry or replaces the one with the same domain, path and name
+func (j *cookieJar) put(entry cookieEntry) {
+	scheme := "http"
+	if entry.secure {
+		scheme = "https"
+	}
+	cookie := &http.Cookie{Name: entry.name, Value: entry.value, Path: entry.path, Secure: entry.secure, HttpOnly: entry.httpOnly, Expires: entry.expires}
+	if !entry.hostOnly {
+		cookie.Domain = entry.domain
+	}
+	if !entry.expires.IsZero() && entry.expires.Before(time.Now()) {
+		cookie.MaxAge = -1
+	}
+	j.jar.SetCookies(&urls.URL{Scheme: scheme, Host: entry.domain, Path: entry.path}, []*http.Cookie{cookie})
+
+	for i, e := range j.entries {
+		if e.domain == entry.domain && e.path == entry.path && e.name == entry.name {
+			j.entries = append(j.entries[:i], j.entries[i+1:]...)
+			break
+		}
+	}
+	if cookie.MaxAge >= 0 {
+		j.entries = append(j.entries, entry)
+	}
+}
+
+// setCookies stores the cookies received for the URL, e.g. from the Set-Cookie headers of the response
+func (j *cookieJar) setCookies(u *urls.URL, cookies []*http.Cookie) {
+	host := strings.ToLower(u.Hostname())
+	for _, c := range cookies {
+		entry := cookieEntry{domain: host, hostOnly: true, path: c.Path, secure: c.Secure, httpOnly: c.HttpOnly, expires: c.Expires, name: c.Name, value: c.Value}
+		if c.Domain != "" {
+			domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+			// The server could set the cookie only for its own domain or a parent one
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue
+			}
+			entry.domain, entry.hostOnly = domain, false
+		}
+		if entry.path == "" || !strings.HasPrefix(entry.path, "/") {
+			entry.path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge < 0:
+			entry.expires = time.Unix(1, 0)
+		case c.MaxAge > 0:
+			entry.expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		j.put(entry)
+	}
+}
+
+// setHeader sets the Cookie header of the request to the literal and the matching cookies, so it's signed along
+// with the other headers. The Cookie header given with -H is kept in front of them, if withExplicit is set.
+func (j *cookieJar) setHeader(req *http.Request, withExplicit bool) {
+	var pairs []string
+	if withExplicit && j.explicit != "" {
+		pairs = append(pairs, j.explicit)
+	}
+	pairs = append(pairs, j.literal...)
+	for _, c := range j.jar.Cookies(req.URL) {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+
+	if len(pairs) == 0 {
+		req.Header.Del("Cookie")
+		return
+	}
+	req.Header.Set("Cookie", strings.Join(pairs, "; "))
+}
+
+// save writes all the cookies except the expired ones to the file in the Netscape cookie file format
+func (j *cookieJar) save(file string) error {
+	var b strings.Builder
+	b.WriteString(netscapeCookieHeader)
+	for _, e := range j.entries {
+		if !e.expires.IsZero() && e.expires.Before(time.Now()) {
+			continue
+		}
+		domain := e.domain
+		if !e.hostOnly {
+			domain = "." + domain
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !e.expires.IsZero() {
+			expires = e.expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, netscapeBool(!e.hostOnly), e.path, netscapeBool(e.secure), expires, e.name, e.value)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("Error: Unable to write the cookie jar: %s", err)
+	}
+	return nil
+}
+
+// defaultCookiePath returns the path of the cookie set without the Path attribute, as defined in RFC 6265
+func defaultCookiePath(urlPath string) string {
+	if urlPath == "" || !strings.HasPrefix(urlPath, "/") || strings.Count(urlPath, "/") == 1 {
+		return "/"
+	}
+	return path.Dir(urlPath)
+}
+
+func netscapeBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
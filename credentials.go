@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// stsDefaultRegion is used to call STS if the region is not configured, the global STS endpoint is served there
+	stsDefaultRegion = "us-east-1"
+	// credentialsTimeout bounds the time to resolve the credentials, e.g. to run credential_process or to call IMDS
+	credentialsTimeout = 2 * time.Minute
+)
+
+// retrieveCredentials resolves the credentials of the config
+func retrieveCredentials(cfg aws.Config) (aws.Credentials, error) {
+	if cfg.Credentials == nil {
+		return aws.Credentials{}, fmt.Errorf("Error: No AWS credentials found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsTimeout)
+	defer cancel()
+
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return creds, fmt.Errorf("Error: Unable to resolve the AWS credentials within %s: %s", credentialsTimeout, err)
+	}
+	return creds, err
+}
+
+// cachedCredentials caches the credentials until they are about to expire, so the role is assumed, the SSO token
+// is exchanged, etc. only once for all the requests signed with them
+type cachedCredentials struct {
+	cache *aws.CredentialsCache
+}
+
+// cacheCredentials wraps the provider with the cache, unless it's already cached
+func cacheCredentials(provider aws.CredentialsProvider) aws.CredentialsProvider {
+	if provider == nil {
+		return nil
+	}
+	if _, ok := provider.(*cachedCredentials); ok {
+		return provider
+	}
+	return &cachedCredentials{cache: aws.NewCredentialsCache(provider)}
+}
+
+func (p *cachedCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.cache.Retrieve(ctx)
+	// The cache only wraps the error of the provider with a prefix, while the provider error already tells what has failed
+	if inner := errors.Unwrap(err); inner != nil {
+		return creds, inner
+	}
+	return creds, err
+}
+
+// checkSessionToken checks the session token is given along with the static keys given with --access-key and --secret-key.
+// The temporary keys (starting with "ASIA") are always rejected by AWS without the token, so it's an error to miss it.
+func checkSessionToken(accessKey, secretKey, sessionToken string) error {
+	if accessKey != "" && secretKey != "" && sessionToken == "" && strings.HasPrefix(accessKey, "ASIA") {
+		return fmt.Errorf("Error: The access key %s is temporary, it requires the session token. Pass it with --session-token", accessKey)
+	}
+	return nil
+}
+
+// sharedConfigProfile returns the name of the profile the shared config is loaded for
+func sharedConfigProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	if env := os.Getenv("AWS_PROFILE"); env != "" {
+		return env
+	}
+	return "default"
+}
+
+// loadSharedConfigProfile loads the profile from the shared config and credentials files. AWS_CONFIG_FILE and
+// AWS_SHARED_CREDENTIALS_FILE replace the default files, the same as in config.LoadDefaultConfig.
+func loadSharedConfigProfile(ctx context.Context, profile string) (config.SharedConfig, error) {
+	env, err := config.NewEnvConfig()
+	if err != nil {
+		return config.SharedConfig{}, err
+	}
+	return config.LoadSharedConfigProfile(ctx, profile, func(o *config.LoadSharedConfigOptions) {
+		if env.SharedConfigFile != "" {
+			o.ConfigFiles = []string{env.SharedConfigFile}
+		}
+		if env.SharedCredentialsFile != "" {
+			o.CredentialsFiles = []string{env.SharedCredentialsFile}
+		}
+	})
+}
+
+// instanceProfileProvider gets the credentials of the EC2 instance profile from IMDS.
+// The IMDS client gets the session token first, so it works with IMDSv2 required as well.
+type instanceProfileProvider struct {
+	provider aws.CredentialsProvider
+	endpoint string
+}
+
+func newInstanceProfileProvider(endpoint string) *instanceProfileProvider {
+	provider := ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+		// It's requested explicitly, so AWS_EC2_METADATA_DISABLED meant for the default chain is ignored
+		o.Client = imds.New(imds.Options{Endpoint: endpoint, ClientEnableState: imds.ClientEnabled})
+	})
+	// The credentials are cached by getAWSConfig, along with any other ones
+	return &instanceProfileProvider{provider: provider, endpoint: endpoint}
+}
+
+func (p *instanceProfileProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		endpoint := p.endpoint
+		if endpoint == "" {
+			endpoint = "the default IMDS endpoint"
+		}
+		return creds, fmt.Errorf("Error: Unable to get the instance profile credentials from %s: %s. "+
+			"Make sure awscurl runs on EC2 with an instance profile attached and IMDS is reachable", endpoint, err)
+	}
+	return creds, nil
+}
+
+// ssoProvider reports the failures to get the credentials of the SSO profile with the hint to sign in again
+type ssoProvider struct {
+	provider aws.CredentialsProvider
+	profile  string
+}
+
+func (p *ssoProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf(`Error: Unable to get the SSO credentials of the profile %s: %s. `+
+			`If the SSO session has expired, sign in again with "aws sso login --profile %s"`, p.profile, err, p.profile)
+	}
+	return creds, nil
+}
+
+// webIdentityProvider assumes the IAM role with the web identity token from the file, e.g. with IRSA in EKS.
+// The token file is re-read on every retrieval, as it's rotated by the cluster.
+type webIdentityProvider struct {
+	provider  aws.CredentialsProvider
+	roleARN   string
+	tokenFile string
+}
+
+func newWebIdentityProvider(cfg aws.Config, roleARN, tokenFile, sessionName string) *webIdentityProvider {
+	client := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if o.Region == "" {
+			o.Region = stsDefaultRegion
+		}
+		// AssumeRoleWithWebIdentity is authenticated with the token only
+		o.Credentials = aws.AnonymousCredentials{}
+	})
+
+	provider := stscreds.NewWebIdentityRoleProvider(client, roleARN, stscreds.IdentityTokenFile(tokenFile), func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = sessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = "awscurl-" + strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	})
+	// The credentials are cached by getAWSConfig, along with any other ones
+	return &webIdentityProvider{provider: provider, roleARN: roleARN, tokenFile: tokenFile}
+}
+
+func (p *webIdentityProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	// The SDK reports the unreadable token in the same way as the STS failures, so it's checked first
+	if _, err := ioutil.ReadFile(p.tokenFile); err != nil {
+		return aws.Credentials{}, fmt.Errorf("Error: Unable to read the web identity token file: %s", err)
+	}
+
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("Error: Unable to assume the role %s with the web identity token from %s: %s", p.roleARN, p.tokenFile, err)
+	}
+	return creds, nil
+}
+
+// assumeRoleProvider assumes the IAM role using the base credentials of the config.
+// The STS errors (e.g. AccessDenied) are reported along with the role ARN, so they aren't confused with the request errors.
+type assumeRoleProvider struct {
+	provider *stscreds.AssumeRoleProvider
+	roleARN  string
+	mfa      mfaDevice
+}
+
+// mfaDevice is the MFA device given with --serial-number and its current --token-code. It's empty if MFA isn't used
+type mfaDevice struct {
+	serialNumber string
+	tokenCode    string
+}
+
+// newSTSClient returns the STS client calling with the credentials of the config
+func newSTSClient(cfg aws.Config) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if o.Region == "" {
+			o.Region = stsDefaultRegion
+		}
+	})
+}
+
+func newAssumeRoleProvider(cfg aws.Config, roleARN, sessionName, externalID string, mfa mfaDevice) *assumeRoleProvider {
+	client := newSTSClient(cfg)
+
+	provider := stscreds.NewAssumeRoleProvider(client, roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = sessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = "awscurl-" + strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+		if mfa.serialNumber != "" {
+			o.SerialNumber = aws.String(mfa.serialNumber)
+			o.TokenProvider = func() (string, error) { return mfa.tokenCode, nil }
+		}
+	})
+	return &assumeRoleProvider{provider: provider, roleARN: roleARN, mfa: mfa}
+}
+
+func (p *assumeRoleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		// STS doesn't tell the missing MFA apart from the other reasons of AccessDenied
+		var ae smithy.APIError
+		if p.mfa.serialNumber == "" && errors.As(err, &ae) && ae.ErrorCode() == "AccessDenied" {
+			return creds, fmt.Errorf("Error: Unable to assume the role %s: %s. If the role requires MFA, pass --serial-number and --token-code", p.roleARN, err)
+		}
+		return creds, fmt.Errorf("Error: Unable to assume the role %s: %s", p.roleARN, err)
+	}
+	return creds, nil
+}
+
+// sessionTokenProvider gets the temporary credentials authenticated with MFA (GetSessionToken) for the base credentials
+type sessionTokenProvider struct {
+	provider aws.CredentialsProvider
+	mfa      mfaDevice
+}
+
+func newSessionTokenProvider(cfg aws.Config, mfa mfaDevice) *sessionTokenProvider {
+	client := newSTSClient(cfg)
+	provider := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return getSessionToken(ctx, client, mfa)
+	})
+	// The credentials are cached by getAWSConfig, so the token code is used only once
+	return &sessionTokenProvider{provider: provider, mfa: mfa}
+}
+
+func (p *sessionTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("Error: Unable to get the session token with the MFA device %s: %s", p.mfa.serialNumber, err)
+	}
+	return creds, nil
+}
+
+func getSessionToken(ctx context.Context, client *sts.Client, mfa mfaDevice) (aws.Credentials, error) {
+	out, err := client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		SerialNumber: aws.String(mfa.serialNumber),
+		TokenCode:    aws.String(mfa.tokenCode),
+	})
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Source:          "GetSessionToken",
+		CanExpire:       true,
+		Expires:         aws.ToTime(out.Credentials.Expiration),
+	}, nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/legal90/awscurl/pkg/awscurl"
+)
+
+// setupSharedConfig writes the shared config file and points AWS_CONFIG_FILE to it, with no other credentials around
+func setupSharedConfig(t *testing.T, content string) {
+	t.Helper()
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func TestCredentialProcessProfile(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "credentials.sh")
+	output := `{"Version": 1, "AccessKeyId": "AKIDPROCESS", "SecretAccessKey": "process-secret", "SessionToken": "process-token"}`
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho '"+output+"'\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	setupSharedConfig(t, "[profile proc]\nregion = eu-west-1\ncredential_process = "+script+"\n")
+
+	cfg, err := getAWSConfig(awsCURLFlags{awsProfile: "proc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := retrieveCredentials(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.AccessKeyID != "AKIDPROCESS" || creds.SecretAccessKey != "process-secret" || creds.SessionToken != "process-token" {
+		t.Errorf("credentials = %+v, want the ones printed by credential_process", creds)
+	}
+	if cfg.Region != "eu-west-1" {
+		t.Errorf("region = %q, want the profile region", cfg.Region)
+	}
+}
+
+func TestSSOProfileDetection(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		sso     bool
+	}{
+		{
+			name:    "legacy sso profile",
+			config:  "[profile dev]\nsso_start_url = https://example.awsapps.com/start\nsso_region = us-east-1\nsso_account_id = 123456789012\nsso_role_name = Dev\n",
+			profile: "dev",
+			sso:     true,
+		},
+		{
+			name: "sso session",
+			config: "[profile dev]\nsso_session = corp\nsso_account_id = 123456789012\nsso_role_name = Dev\n\n" +
+				"[sso-session corp]\nsso_start_url = https://example.awsapps.com/start\nsso_region = us-east-1\n",
+			profile: "dev",
+			sso:     true,
+		},
+		{
+			name:    "static keys",
+			config:  "[profile dev]\naws_access_key_id = AKIDEXAMPLE\naws_secret_access_key = secret\n",
+			profile: "dev",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The profile is read from AWS_CONFIG_FILE, the same as config.LoadDefaultConfig does
+			setupSharedConfig(t, tt.config)
+
+			cfg, err := loadAWSConfig(awsCURLFlags{awsProfile: tt.profile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, sso := cfg.Credentials.(*ssoProvider)
+			if sso != tt.sso {
+				t.Errorf("credentials = %T, SSO provider expected: %v", cfg.Credentials, tt.sso)
+			}
+		})
+	}
+}
+
+func TestSSOProviderError(t *testing.T) {
+	setupSharedConfig(t, "[profile dev]\nsso_start_url = https://example.awsapps.com/start\nsso_region = us-east-1\n"+
+		"sso_account_id = 123456789012\nsso_role_name = Dev\n")
+	// There is no cached SSO token, as if the user has never signed in
+	t.Setenv("HOME", t.TempDir())
+
+	cfg, err := getAWSConfig(awsCURLFlags{awsProfile: "dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = retrieveCredentials(cfg)
+	if err == nil || !strings.Contains(err.Error(), `aws sso login --profile dev`) {
+		t.Errorf("retrieveCredentials() error = %v, want the hint to sign in", err)
+	}
+}
+
+// countingProvider returns the given credentials or the error, counting the calls
+type countingProvider struct {
+	creds aws.Credentials
+	err   error
+	calls int
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.calls++
+	return p.creds, p.err
+}
+
+func TestCachedCredentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		creds     aws.Credentials
+		err       error
+		wantCalls int
+	}{
+		{name: "static", creds: aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, wantCalls: 1},
+		{name: "valid temporary", creds: aws.Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", CanExpire: true, Expires: time.Now().Add(time.Hour)}, wantCalls: 1},
+		{name: "expired", creds: aws.Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", CanExpire: true, Expires: time.Now().Add(-time.Minute)}, wantCalls: 3},
+		{name: "error", err: errors.New("Error: Unable to assume the role"), wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &countingProvider{creds: tt.creds, err: tt.err}
+			cached := cacheCredentials(provider)
+			if cacheCredentials(cached) != cached {
+				t.Error("the cached provider is cached again")
+			}
+
+			for i := 0; i < 3; i++ {
+				_, err := cached.Retrieve(context.Background())
+				// The error of the provider is returned as is, without the prefix of the SDK cache
+				if (err == nil) != (tt.err == nil) || (err != nil && err.Error() != tt.err.Error()) {
+					t.Fatalf("Retrieve() error = %v, want %v", err, tt.err)
+				}
+			}
+			if provider.calls != tt.wantCalls {
+				t.Errorf("the provider is called %d times, want %d", provider.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCheckSessionToken(t *testing.T) {
+	tests := []struct {
+		accessKey, secretKey, sessionToken string
+		wantErr                            bool
+	}{
+		{accessKey: "AKIDEXAMPLE", secretKey: "secret"},
+		{accessKey: "ASIAEXAMPLE", secretKey: "secret", sessionToken: "token"},
+		{accessKey: "ASIAEXAMPLE", secretKey: "secret", wantErr: true},
+		// Without the secret key, the static keys are not used at all
+		{accessKey: "ASIAEXAMPLE"},
+	}
+	for _, tt := range tests {
+		err := checkSessionToken(tt.accessKey, tt.secretKey, tt.sessionToken)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkSessionToken(%q, %q, %q) error = %v, wantErr %v", tt.accessKey, tt.secretKey, tt.sessionToken, err, tt.wantErr)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "Error: ") {
+			t.Errorf("the error isn't prefixed with \"Error: \" as the other ones: %s", err)
+		}
+	}
+}
+
+func TestStaticCredentialsProviderSessionToken(t *testing.T) {
+	creds, err := credentials.NewStaticCredentialsProvider("ASIAEXAMPLE", "secret", "token").Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]*awscurl.Signer{
+		"sigv4":       {},
+		"date header": {DateHeader: "Date"},
+		"sigv4a":      {SigV4A: true},
+	}
+	for name, signer := range signers {
+		t.Run(name, func(t *testing.T) {
+			signer.Credentials, signer.Service, signer.Region = creds, "execute-api", "us-east-1"
+			req, _ := http.NewRequest(http.MethodGet, "https://example.execute-api.us-east-1.amazonaws.com/", nil)
+			if _, err := signer.Sign(req, hashSHA256(nil)); err != nil {
+				t.Fatal(err)
+			}
+			if token := req.Header.Get("X-Amz-Security-Token"); token != "token" {
+				t.Errorf("X-Amz-Security-Token = %q, want %q", token, "token")
+			}
+			if !strings.Contains(req.Header.Get("Authorization"), "x-amz-security-token") {
+				t.Errorf("X-Amz-Security-Token isn't signed: %s", req.Header.Get("Authorization"))
+			}
+		})
+	}
+
+	presigner := &awscurl.Signer{Credentials: creds, Service: "s3", Region: "us-east-1"}
+	req, _ := http.NewRequest(http.MethodGet, "https://bucket.s3.amazonaws.com/key", nil)
+	presigned, err := presign(presigner, req, unsignedPayload, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(presigned, "X-Amz-Security-Token=token") {
+		t.Errorf("the presigned URL has no security token: %s", presigned)
+	}
+}
+
+// newSTSServer returns the fake STS endpoint answering AssumeRoleWithWebIdentity with the given status, recording the form
+func newSTSServer(t *testing.T, status int, form *url.Values) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*form = r.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>Not authorized</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>`+
+			`<AccessKeyId>ASIAWEBIDENTITY</AccessKeyId><SecretAccessKey>web-identity-secret</SecretAccessKey>`+
+			`<SessionToken>web-identity-token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration>`+
+			`</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/web"
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("dummy-jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tokenFile string
+		status    int
+		wantErr   string
+	}{
+		{name: "assumed", tokenFile: tokenFile, status: http.StatusOK},
+		{name: "unreadable token file", tokenFile: filepath.Join(t.TempDir(), "missing"), status: http.StatusOK, wantErr: "Error: Unable to read the web identity token file: "},
+		{name: "sts failure", tokenFile: tokenFile, status: http.StatusForbidden, wantErr: "Error: Unable to assume the role " + roleARN + " with the web identity token from " + tokenFile + ": "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form url.Values
+			server := newSTSServer(t, tt.status, &form)
+			cfg := aws.Config{
+				HTTPClient: server.Client(),
+				EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: server.URL, SigningRegion: region}, nil
+				}),
+			}
+
+			creds, err := newWebIdentityProvider(cfg, roleARN, tt.tokenFile, "session").Retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("Retrieve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != "ASIAWEBIDENTITY" || creds.SessionToken != "web-identity-token" {
+				t.Errorf("credentials = %+v, want the ones returned by STS", creds)
+			}
+			if form.Get("Action") != "AssumeRoleWithWebIdentity" || form.Get("RoleArn") != roleARN ||
+				form.Get("WebIdentityToken") != "dummy-jwt" || form.Get("RoleSessionName") != "session" {
+				t.Errorf("STS is called with %v", form)
+			}
+		})
+	}
+}
+
+func TestWebIdentityRequiresRole(t *testing.T) {
+	setupSharedConfig(t, "")
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	_, err := loadAWSConfig(awsCURLFlags{webIdentityTokenFile: tokenFile})
+	if err == nil || !strings.Contains(err.Error(), "requires the role to assume") {
+		t.Errorf("loadAWSConfig() error = %v, want the role to be required", err)
+	}
+
+	// AWS_ROLE_ARN is enough, the same as for the SDK
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/web")
+	cfg, err := loadAWSConfig(awsCURLFlags{webIdentityTokenFile: tokenFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, ok := cfg.Credentials.(*webIdentityProvider)
+	if !ok || provider.roleARN != "arn:aws:iam::123456789012:role/web" {
+		t.Fatalf("credentials = %#v, want the web identity provider of AWS_ROLE_ARN", cfg.Credentials)
+	}
+	// getAWSConfig caches the credentials of any provider, so they aren't cached twice
+	if _, cached := provider.provider.(*aws.CredentialsCache); cached {
+		t.Error("the web identity provider caches the credentials on its own")
+	}
+}
+
+func TestAssumeRoleMFAHint(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/admin"
+	tests := []struct {
+		name     string
+		code     string
+		mfa      mfaDevice
+		wantHint bool
+	}{
+		{name: "access denied", code: "AccessDenied", wantHint: true},
+		{name: "access denied with MFA", code: "AccessDenied", mfa: mfaDevice{serialNumber: "arn:aws:iam::123456789012:mfa/user", tokenCode: "123456"}},
+		// The error code is checked, not the message, which could mention anything
+		{name: "other error", code: "ValidationError"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>Not AccessDenied</Message></Error></ErrorResponse>`, tt.code)
+			}))
+			defer server.Close()
+			cfg := aws.Config{
+				Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+					return testCredentials, nil
+				}),
+				HTTPClient: server.Client(),
+				EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+					return aws.Endpoint{URL: server.URL, SigningRegion: region}, nil
+				}),
+			}
+
+			_, err := newAssumeRoleProvider(cfg, roleARN, "session", "", tt.mfa).Retrieve(context.Background())
+			if err == nil || !strings.HasPrefix(err.Error(), "Error: Unable to assume the role "+roleARN+": ") {
+				t.Fatalf("Retrieve() error = %v, want the role to be reported", err)
+			}
+			if hint := strings.Contains(err.Error(), "pass --serial-number and --token-code"); hint != tt.wantHint {
+				t.Errorf("Retrieve() error = %v, want the MFA hint: %v", err, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestSessionTokenProviderCached(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, `<GetSessionTokenResponse><GetSessionTokenResult><Credentials>`+
+			`<AccessKeyId>ASIAMFA</AccessKeyId><SecretAccessKey>mfa-secret</SecretAccessKey>`+
+			`<SessionToken>mfa-token</SessionToken><Expiration>2100-01-01T00:00:00Z</Expiration>`+
+			`</Credentials></GetSessionTokenResult></GetSessionTokenResponse>`)
+	}))
+	defer server.Close()
+	cfg := aws.Config{
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return testCredentials, nil
+		}),
+		HTTPClient: server.Client(),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: server.URL, SigningRegion: region}, nil
+		}),
+	}
+
+	// The token code is valid only once, so the credentials must be cached, but only by the single cache of getAWSConfig
+	provider := newSessionTokenProvider(cfg, mfaDevice{serialNumber: "arn:aws:iam::123456789012:mfa/user", tokenCode: "123456"})
+	if _, cached := provider.provider.(*aws.CredentialsCache); cached {
+		t.Error("the session token provider caches the credentials on its own")
+	}
+	cached := cacheCredentials(provider)
+	for i := 0; i < 3; i++ {
+		creds, err := cached.Retrieve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "ASIAMFA" || creds.SessionToken != "mfa-token" {
+			t.Errorf("credentials = %+v, want the ones returned by STS", creds)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("STS is called %d times, want once", calls)
+	}
+}
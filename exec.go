@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+)
+
+// execCmd runs a command with the AWS credentials resolved by awscurl
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- COMMAND [ARGS...]",
+	Short: "Run a command with the resolved AWS credentials in its environment",
+	Long: `Resolve the AWS credentials the same way as for sending a request and run the given command
+with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN set in its environment.
+AWS_REGION and AWS_DEFAULT_REGION are set as well, if the region is known.
+The exit code of the command is propagated.
+`,
+	Args:    cobra.MinimumNArgs(1),
+	PreRunE: loadConfig,
+	RunE:    runExec,
+}
+
+func init() {
+	// Everything after the command name belongs to the command, even without "--"
+	execCmd.Flags().SetInterspersed(false)
+}
+
+func runExec(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	cfg, err := getAWSConfig(flags)
+	if err != nil {
+		return err
+	}
+	creds, err := retrieveCredentials(cfg)
+	if err != nil {
+		return err
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = credentialsEnv(os.Environ(), creds, cfg.Region)
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	// The command handles the interrupt on its own, awscurl just waits for it to exit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			c.Process.Signal(sig)
+		}
+	}()
+
+	err = c.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// The command has already reported its failure, only the exit code is propagated
+		cmd.SilenceErrors = true
+	}
+	return err
+}
+
+// credentialsEnv returns the environment with the AWS credentials variables replaced by the given credentials.
+// The variables which could override them in the command (e.g. a stale session token or a profile) are removed.
+func credentialsEnv(environ []string, creds aws.Credentials, region string) []string {
+	overridden := map[string]bool{
+		"AWS_ACCESS_KEY_ID":     true,
+		"AWS_SECRET_ACCESS_KEY": true,
+		"AWS_SESSION_TOKEN":     true,
+		"AWS_SECURITY_TOKEN":    true,
+		"AWS_PROFILE":           true,
+		"AWS_DEFAULT_PROFILE":   true,
+	}
+	if region != "" {
+		overridden["AWS_REGION"] = true
+		overridden["AWS_DEFAULT_REGION"] = true
+	}
+
+	var env []string
+	for _, kv := range environ {
+		if !overridden[strings.SplitN(kv, "=", 2)[0]] {
+			env = append(env, kv)
+		}
+	}
+
+	env = append(env, "AWS_ACCESS_KEY_ID="+creds.AccessKeyID, "AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey)
+	if creds.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+creds.SessionToken)
+	}
+	if region != "" {
+		env = append(env, "AWS_REGION="+region, "AWS_DEFAULT_REGION="+region)
+	}
+	return env
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+)
+
+// quoteEscaper escapes the values of the Content-Disposition parameters, the same as mime/multipart does
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// formField is a single -F/--form field
+type formField struct {
+	name        string
+	value       string
+	contentType string
+	filename    string
+	// file is the path of the file to read the value from, "-" is stdin
+	file string
+}
+
+// parseFormField parses a -F value in the curl format: "name=value", "name=@file" to upload the file
+// or "name=<file" to send the content of the file as the value.
+// The ";type=" and ";filename=" options set the content type and the file name of the part.
+func parseFormField(field string) (formField, error) {
+	i := strings.Index(field, "=")
+	if i <= 0 {
+		return formField{}, fmt.Errorf(`Error: Invalid --form value: %s. It should be "name=value", "name=@file" or "name=<file"`, field)
+	}
+	f := formField{name: field[:i]}
+
+	options := strings.Split(field[i+1:], ";")
+	f.value = options[0]
+	for _, option := range options[1:] {
+		switch {
+		case strings.HasPrefix(option, "type="):
+			f.contentType = strings.TrimPrefix(option, "type=")
+		case strings.HasPrefix(option, "filename="):
+			f.filename = strings.TrimPrefix(option, "filename=")
+		default:
+			// Not an option, but a part of the value itself
+			f.value += ";" + option
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(f.value, "@"):
+		f.file = f.value[1:]
+		if f.filename == "" && f.file != "-" {
+			f.filename = filepath.Base(f.file)
+		}
+		if f.contentType == "" {
+			f.contentType = "application/octet-stream"
+		}
+	case strings.HasPrefix(f.value, "<"):
+		// Unlike "@", the content is sent as a plain value, without the file name
+		f.file = f.value[1:]
+	}
+	return f, nil
+}
+
+// buildMultipartForm builds the multipart/form-data body from the -F fields.
+// It returns the whole body, as it must be hashed for signing anyway, and the content type with the boundary.
+func buildMultipartForm(fields []string) (io.Reader, string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	for _, field := range fields {
+		f, err := parseFormField(field)
+		if err != nil {
+			return nil, "", err
+		}
+
+		header := make(textproto.MIMEHeader)
+		disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(f.name))
+		if f.filename != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(f.filename))
+		}
+		header.Set("Content-Disposition", disposition)
+		if f.contentType != "" {
+			header.Set("Content-Type", f.contentType)
+		}
+
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if f.file == "" {
+			if _, err := io.WriteString(part, f.value); err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+
+		r, err := openDataFile(f.file, false)
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.Copy(part, r)
+		if c, ok := r.(io.Closer); ok && f.file != "-" {
+			c.Close()
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(body.Bytes()), w.FormDataContentType(), nil
+}
@@ -2,40 +2,149 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"crypto/tls"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	urls "net/url"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/legal90/awscurl/pkg/awscurl"
 	"github.com/spf13/cobra"
 )
 
 type awsCURLFlags struct {
-	headers []string
-	method  string
-	data    string
+	headers         []string
+	method          string
+	data            []string
+	dataASCII       string
+	dataBinary      string
+	dataBase64      string
+	dataURLEncode   []string
+	userAgent       string
+	cookies         []string
+	cookieJar       string
+	form            []string
+	decompressInput bool
 
-	awsAccessKey    string
-	awsSecretKey    string
-	awsSessionToken string
-	awsProfile      string
-	awsService      string
-	awsRegion       string
-	include         bool
-	insecure        bool
-	proxy           string
+	awsAccessKey         string
+	awsSecretKey         string
+	awsSessionToken      string
+	awsProfile           string
+	roleARN              string
+	roleSessionName      string
+	externalID           string
+	webIdentityTokenFile string
+	serialNumber         string
+	tokenCode            string
+	awsService           string
+	signingName          string
+	awsRegion            string
+	dnsSuffix            string
+	awsSigV4             string
+	include              bool
+	head                 bool
+	jsonOutput           bool
+	dumpHeader           string
+	verbose              bool
+	trace                string
+	traceASCII           string
+	traceRedact          bool
+	insecure             bool
+	caCert               string
+	cert                 string
+	key                  string
+	proxy                string
+	noProxy              string
+	proxyUser            string
+	netrc                bool
+	netrcFile            string
+	unixSocket           string
+	parseErrors          bool
+	dateHeader           string
+	date                 string
+	hostProfileMap       []string
+	dumpCanonical        string
+	dumpSigning          bool
+	noNewline            bool
+	failOnRedirect       bool
+	location             bool
+	maxRedirs            int
+	post301              bool
+	post302              bool
+	post303              bool
+	traceID              bool
+	traceIDHeader        string
+	traceIDValue         string
+	fail                 bool
+	strict               bool
+	abortOnAuthError     bool
+	abortOnEmptyBody     bool
+	timingJSON           string
+	writeOut             string
+	noDNSCache           bool
+	requestPayer         bool
+	retry                int
+	retryConnRefused     bool
+	retryMaxTime         time.Duration
+	connectTimeout       time.Duration
+	maxTime              time.Duration
+	dnsTimeout           time.Duration
+	tlsTimeout           time.Duration
+	headerTimeout        time.Duration
+	expect100            bool
+	expect100Timeout     time.Duration
+	echo                 bool
+	presign              bool
+	expires              time.Duration
+	snippet              string
+	dryRun               bool
+	output               string
+	noClobber            bool
+	byteRange            string
+	maxFileSize          string
+	continueAt           string
+	discard              bool
+	progressBar          bool
+	check                bool
+	outputFilter         string
+	pretty               bool
+	autoDecompress       bool
+	writeMetadata        string
+	verifyETag           bool
+	noContentSHA256      bool
+	sigV4A               bool
+	regionSet            string
+	silent               bool
+	instanceProfile      bool
+	compressed           bool
+	exitStatus           bool
+	get                  bool
+	resolve              []string
+	connectTo            []string
+	networkInterface     string
+	ipv4                 bool
+	ipv6                 bool
+	limitRate            string
+	http11               bool
+	http2                bool
+	query                []string
+	imdsEndpoint         string
+	config               string
 }
 
 var (
@@ -45,9 +154,12 @@ var (
 	commit  = "none"
 
 	flags awsCURLFlags
+
+	// diagnostics receives the warnings and the progress. Unlike the errors, they are suppressed with --silent
+	diagnostics io.Writer = os.Stderr
 )
 
-// rootCmd represents the base awscurl command when called without any subcommands (which we don't have here)
+// rootCmd signs and sends the request to the URL. The subcommands are registered in init()
 var rootCmd = &cobra.Command{
 	Use:   "awscurl [URL]",
 	Short: "cURL with AWS request signing",
@@ -56,32 +168,236 @@ It automatically adds Signature Version 4 to the request. More details:
 https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
 `,
 	Args:    cobra.ExactArgs(1),
-	RunE:    runCurl,
+	PreRunE: loadConfig,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if flags.check {
+			return runCheck(cmd, args)
+		}
+		return runCurl(cmd, args)
+	},
 	Version: fmt.Sprintf("%s, build %s", version, commit),
+
+	// Cobra adds the "completion" command generating the shell completion scripts, since there are subcommands.
+	// The completion of the flag values is registered in registerCompletions.
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
+		var codeErr exitCoder
+		if errors.As(err, &codeErr) {
+			os.Exit(codeErr.exitCode())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&flags.method, "request", "X", "GET", "Custom request method to use")
-	rootCmd.PersistentFlags().StringVarP(&flags.data, "data", "d", "", `Data payload to send within a request. Could be also read from a file if prefixed with @, example: -d "@/path/to/file.json"`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flags.data, "data", "d", nil, `Data payload to send within a request. Could be also read from a file if prefixed with @, example: -d "@/path/to/file.json", or from stdin with -d @-. `+
+		`Could be used multiple times, the parts are joined with "&"`)
+	rootCmd.PersistentFlags().StringVar(&flags.dataASCII, "data-ascii", "",
+		"The same as --data, but carriage returns and newlines are removed from the payload, as curl does for -d")
+	rootCmd.PersistentFlags().StringVar(&flags.dataBinary, "data-binary", "", "The same as --data: the payload is sent exactly as given")
+	rootCmd.PersistentFlags().StringVar(&flags.dataBase64, "data-base64", "", "Base64-encoded data payload to decode and send within a request as is")
+	rootCmd.PersistentFlags().BoolVarP(&flags.get, "get", "G", false, "Send the data given with -d or --data-urlencode in the URL query instead of the body")
+	rootCmd.PersistentFlags().StringArrayVar(&flags.query, "query", nil,
+		`Query parameter "name=value" to add to the URL, the name and the value are URL-encoded. Could be used multiple times`)
+	rootCmd.PersistentFlags().StringArrayVar(&flags.dataURLEncode, "data-urlencode", nil,
+		`URL-encoded form data: "content", "name=content" or "name@file" to take the content from the file. Could be used multiple times, the parts are joined with "&"`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flags.form, "form", "F", nil,
+		`Multipart form field: "name=value", "name=@file" to upload the file or "name=<file" to take the value from the file. `+
+			`The part content type and file name could be set with ";type=" and ";filename=". Could be used multiple times`)
+	rootCmd.PersistentFlags().BoolVar(&flags.decompressInput, "decompress-input", false,
+		`Decompress the gzipped data file before sending it, example: --decompress-input -d "@/path/to/file.json.gz"`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flags.cookies, "cookie", "b", nil,
+		`Cookies to send, as "NAME=VALUE; NAME2=VALUE2", or the Netscape cookie file to read them from. Could be used multiple times`)
+	rootCmd.PersistentFlags().StringVarP(&flags.cookieJar, "cookie-jar", "c", "",
+		"Write the cookies read with --cookie and received from the server to the given file in the Netscape cookie file format")
+	rootCmd.PersistentFlags().StringVarP(&flags.userAgent, "user-agent", "A", "",
+		`User-Agent header to send, "awscurl/<version>" by default. An empty value disables the header`)
 	rootCmd.PersistentFlags().StringArrayVarP(&flags.headers, "header", "H", []string{},
-		`Extra HTTP header to include in the request. Example: -H "Content-Type: application/json". Could be used multiple times`)
+		`Extra HTTP header to include in the request. Example: -H "Content-Type: application/json". Could be used multiple times. `+
+			`Use "Name;" for a header with an empty value, or @file to read the headers from the file, one per line. Several headers could be also passed in a single value separated with "\n"`)
+	rootCmd.PersistentFlags().BoolVar(&flags.traceID, "trace-id", false, "Add a header with a generated UUID to correlate the request in logs. The ID is printed to stderr")
+	rootCmd.PersistentFlags().StringVar(&flags.traceIDHeader, "trace-id-header", "X-Request-Id",
+		"Header to pass the trace ID in. Please note that X-Amzn-Trace-Id is never included in the signature")
+	rootCmd.PersistentFlags().StringVar(&flags.traceIDValue, "trace-id-value", "", "Use the given trace ID instead of generating one. Implies --trace-id")
 	rootCmd.PersistentFlags().StringVar(&flags.awsAccessKey, "access-key", "", "AWS Access Key ID to use for authentication")
 	rootCmd.PersistentFlags().StringVar(&flags.awsSecretKey, "secret-key", "", "AWS Secret Access Key to use for authentication")
 	rootCmd.PersistentFlags().StringVar(&flags.awsSessionToken, "session-token", "", "AWS Session Key to use for authentication")
+	rootCmd.PersistentFlags().StringVar(&flags.config, "config", "",
+		"YAML file with the defaults for the flags, keyed by the long flag names. Defaults to ~/.awscurl/config, if it exists")
 	rootCmd.PersistentFlags().StringVar(&flags.awsProfile, "profile", "", "AWS awsProfile to use for authentication")
-	rootCmd.PersistentFlags().StringVar(&flags.awsService, "service", "execute-api", "The name of AWS Service, used for signing the request")
-	rootCmd.PersistentFlags().StringVar(&flags.awsRegion, "region", "", "AWS region to use for the request")
+	rootCmd.PersistentFlags().BoolVar(&flags.instanceProfile, "instance-profile", false,
+		"Use the credentials of the EC2 instance profile from the instance metadata service (IMDS), skipping the other credential sources")
+	rootCmd.PersistentFlags().StringVar(&flags.imdsEndpoint, "imds-endpoint", "", `Endpoint of the instance metadata service, example: "http://169.254.169.254"`)
+	rootCmd.PersistentFlags().StringVar(&flags.roleARN, "role-arn", "", "ARN of the IAM role to assume with the resolved credentials, and sign the request as")
+	rootCmd.PersistentFlags().StringVar(&flags.roleSessionName, "role-session-name", "", `Session name for --role-arn. Defaults to "awscurl-<timestamp>"`)
+	rootCmd.PersistentFlags().StringVar(&flags.externalID, "external-id", "", "External ID for --role-arn, if the role trust policy requires it")
+	rootCmd.PersistentFlags().StringVar(&flags.serialNumber, "serial-number", "",
+		"ARN (or serial number) of the MFA device, to assume --role-arn with MFA, or to get the MFA session token for the credentials without --role-arn")
+	rootCmd.PersistentFlags().StringVar(&flags.tokenCode, "token-code", "", "The current code of the --serial-number MFA device")
+	rootCmd.PersistentFlags().StringVar(&flags.webIdentityTokenFile, "web-identity-token-file", "",
+		"File with the web identity token (e.g. of IRSA in EKS) to assume the --role-arn role with. The role defaults to AWS_ROLE_ARN")
+	rootCmd.PersistentFlags().StringVar(&flags.awsService, "service", "execute-api",
+		"The name of AWS Service, used for signing the request. Defaults to AWSCURL_SERVICE, if set, then to the one detected from the URL host, see \"awscurl services\"")
+	rootCmd.PersistentFlags().StringVar(&flags.signingName, "signing-name", "",
+		"The service name to put into the signature, if it differs from --service (e.g. \"execute-api\" for API Gateway Management API)")
+	rootCmd.PersistentFlags().StringArrayVar(&flags.hostProfileMap, "host-profile-map", []string{},
+		`AWS profile to use for requests to the given host, e.g. for each URL of "awscurl bulk". Example: --host-profile-map "example.com=test". Could be used multiple times`)
+	rootCmd.PersistentFlags().StringVar(&flags.awsRegion, "region", "",
+		"AWS region to use for the request. Detected from the URL host if not set, then defaults to AWS_REGION, AWS_DEFAULT_REGION or the profile region")
+	rootCmd.PersistentFlags().StringVar(&flags.awsSigV4, "aws-sigv4", "",
+		`The region and the service in the curl --aws-sigv4 format "aws:amz[:region[:service]]". --region and --service take precedence over it`)
+	rootCmd.PersistentFlags().StringVar(&flags.dnsSuffix, "dns-suffix", defaultDNSSuffix,
+		`DNS suffix of the AWS partition endpoints, used to detect the service by the URL host. Example: "amazonaws.com.cn"`)
+	rootCmd.PersistentFlags().BoolVar(&flags.sigV4A, "sigv4a", false,
+		`Sign the request with SigV4A (AWS4-ECDSA-P256-SHA256), treating --region as a comma-separated region set, e.g. "*", unless --region-set is given. `+
+			"Required by S3 Multi-Region Access Points, EventBridge global endpoints and CloudFront KeyValueStore")
+	rootCmd.PersistentFlags().StringVar(&flags.regionSet, "region-set", "",
+		`Comma-separated set of regions the SigV4A signature is valid in, e.g. "us-east-1,eu-west-1" or "*". Requires --sigv4a, `+
+			"takes precedence over --region and the region detected by the URL host")
+	rootCmd.PersistentFlags().BoolVar(&flags.noContentSHA256, "no-auto-content-sha256", false,
+		"Don't add the X-Amz-Content-Sha256 header to the S3 requests and the ones with the unsigned or streaming payload. Only for endpoints which reject it")
+	rootCmd.PersistentFlags().BoolVar(&flags.requestPayer, "request-payer", false,
+		`Add the "x-amz-request-payer: requester" header to access S3 Requester Pays buckets`)
+	rootCmd.PersistentFlags().StringVar(&flags.date, "date", "",
+		`Sign the request with the given time instead of the current one, in RFC3339 ("2024-01-02T15:04:05Z") or ISO8601 basic ("20240102T150405Z") format`)
+	rootCmd.PersistentFlags().StringVar(&flags.dateHeader, "date-header", amzDateHeader,
+		`Header carrying the signing timestamp. Some S3-compatible services expect "Date" instead of the default`)
+	rootCmd.PersistentFlags().BoolVar(&flags.presign, "presign", false,
+		"Print the presigned URL of the request to stdout instead of sending it, e.g. to share a time-limited link to an S3 object")
+	rootCmd.PersistentFlags().DurationVar(&flags.expires, "expires", 15*time.Minute, "Validity of the --presign URL, up to 7 days")
+	rootCmd.PersistentFlags().BoolVar(&flags.echo, "echo", false,
+		"Print the signed request in the HTTP wire format to stdout instead of sending it. The secret key is never part of the request")
+	rootCmd.PersistentFlags().StringVar(&flags.snippet, "snippet", "",
+		"Print a command reproducing the signed request instead of sending it. Supported formats: "+strings.Join(snippetFormatNames(), ", "))
+	rootCmd.PersistentFlags().BoolVar(&flags.dryRun, "dry-run", false, "Sign the request and print the curl command sending it, instead of sending it. The same as --snippet curl")
+	rootCmd.PersistentFlags().StringVar(&flags.dumpCanonical, "dump-canonical", "",
+		"Write the canonical request, the string to sign and the signing key derivation steps to the given file")
+	rootCmd.PersistentFlags().BoolVar(&flags.dumpSigning, "dump-signing", false,
+		"Print the canonical request, the string to sign and the signing key derivation steps to stderr before sending the request")
 	rootCmd.PersistentFlags().BoolVarP(&flags.include, "include", "i", false, "Include the HTTP response headers in the output.")
+	rootCmd.PersistentFlags().BoolVarP(&flags.head, "head", "I", false, "Send a HEAD request and print only the response status line and headers")
+	rootCmd.PersistentFlags().BoolVar(&flags.jsonOutput, "json-output", false,
+		"Print the whole response as a single JSON object with status_code, headers, body (base64 if binary, see body_encoding) and timing")
+	rootCmd.PersistentFlags().StringVarP(&flags.dumpHeader, "dump-header", "D", "",
+		`Write the response status line and headers to the given file, while the body is written as usual. Use "-" for stdout`)
+	rootCmd.PersistentFlags().BoolVarP(&flags.verbose, "verbose", "v", false,
+		"Print the signed request line, headers and the payload SHA256 to stderr before sending it, then the response status line and headers. The secret key is never printed")
+	rootCmd.PersistentFlags().StringVar(&flags.trace, "trace", "",
+		`Write the full requests and responses as sent and received, including the bodies, to the given file as a hex dump. Use "-" for stdout`)
+	rootCmd.PersistentFlags().StringVar(&flags.traceASCII, "trace-ascii", "", "The same as --trace, but the data is written as text instead of the hex dump")
+	rootCmd.PersistentFlags().BoolVar(&flags.traceRedact, "trace-redact", false, "Hide the signature and the session token in the --trace and --trace-ascii output")
 	rootCmd.PersistentFlags().BoolVarP(&flags.insecure, "insecure", "k", false, "Allow insecure server connections when using SSL")
-	rootCmd.PersistentFlags().StringVarP(&flags.proxy, "proxy", "x", "", `Use the specified HTTP proxy, example: -x "<[protocol://][user:password@]proxyhost[:port]>"`)
+	rootCmd.PersistentFlags().StringVar(&flags.caCert, "cacert", "",
+		"PEM file with the CA certificates to verify the server certificate with, instead of the system ones. Defaults to AWS_CA_BUNDLE")
+	rootCmd.PersistentFlags().StringVar(&flags.cert, "cert", "", "PEM file with the client certificate for mutual TLS. It could contain the private key as well")
+	rootCmd.PersistentFlags().StringVar(&flags.key, "key", "", "PEM file with the private key of the --cert client certificate")
+	rootCmd.PersistentFlags().BoolVar(&flags.noDNSCache, "no-dns-cache", false, "Resolve the host for every new connection instead of caching it within the invocation")
+	rootCmd.PersistentFlags().DurationVar(&flags.connectTimeout, "connect-timeout", 0, `Maximum time for establishing the connection, example: "10s". No timeout by default`)
+	rootCmd.PersistentFlags().DurationVar(&flags.maxTime, "max-time", 0,
+		`Maximum time for the whole operation, including retries and reading the response, example: "2m". No timeout by default`)
+	rootCmd.PersistentFlags().DurationVar(&flags.dnsTimeout, "dns-timeout", 0, `Maximum time for resolving the host, example: "5s". No timeout by default`)
+	rootCmd.PersistentFlags().DurationVar(&flags.tlsTimeout, "tls-timeout", 0, `Maximum time for the TLS handshake, example: "10s". No timeout by default`)
+	rootCmd.PersistentFlags().BoolVar(&flags.expect100, "expect100", false,
+		`Send the signed "Expect: 100-continue" header, so the body is sent only if the server doesn't reject the request first`)
+	rootCmd.PersistentFlags().DurationVar(&flags.expect100Timeout, "expect100-timeout", time.Second,
+		`Maximum time to wait for the "100 Continue" response before sending the body anyway, with "Expect: 100-continue"`)
+	rootCmd.PersistentFlags().DurationVar(&flags.headerTimeout, "response-header-timeout", 0,
+		`Maximum time to wait for the response headers after the request is sent, example: "30s". No timeout by default`)
+	rootCmd.PersistentFlags().IntVar(&flags.retry, "retry", 0,
+		"Retry the request up to the given number of times on connection errors and on 429, 500, 502, 503 and 504 responses, with an exponential backoff")
+	rootCmd.PersistentFlags().DurationVar(&flags.retryMaxTime, "retry-max-time", 0, "Don't start retries after the given time since the first attempt, e.g. 2m")
+	rootCmd.PersistentFlags().BoolVar(&flags.retryConnRefused, "retry-connrefused", false,
+		"Consider \"connection refused\" a transient failure for --retry, e.g. to wait for a starting service")
+	rootCmd.PersistentFlags().StringVarP(&flags.proxy, "proxy", "x", "",
+		`Use the specified HTTP proxy instead of HTTP_PROXY and HTTPS_PROXY, example: -x "<[protocol://][user:password@]proxyhost[:port]>"`)
+	rootCmd.PersistentFlags().StringArrayVar(&flags.resolve, "resolve", nil,
+		`Connect to the given address instead of resolving the host, in the format "host:port:address". The URL host is still sent and signed. Could be used multiple times`)
+	rootCmd.PersistentFlags().StringArrayVar(&flags.connectTo, "connect-to", nil,
+		`Connect to host2:port2 instead of host1:port1, in the format "host1:port1:host2:port2". The URL host is still sent and signed. Could be used multiple times`)
+	rootCmd.PersistentFlags().StringVar(&flags.networkInterface, "interface", "",
+		`Connect from the given local IP address or network interface, e.g. "10.0.1.15" or "eth1". The signature doesn't depend on it`)
+	rootCmd.PersistentFlags().BoolVarP(&flags.ipv4, "ipv4", "4", false, "Connect to the IPv4 addresses of the host only")
+	rootCmd.PersistentFlags().BoolVarP(&flags.ipv6, "ipv6", "6", false, "Connect to the IPv6 addresses of the host only")
+	rootCmd.PersistentFlags().BoolVar(&flags.http11, "http1.1", false, "Use HTTP/1.1 only. It's the default, unless --http2 is given")
+	rootCmd.PersistentFlags().BoolVar(&flags.http2, "http2", false,
+		"Use HTTP/2 only, failing if the server doesn't support it. Plain http:// URLs are requested with HTTP/2 prior knowledge (h2c)")
+	rootCmd.PersistentFlags().StringVar(&flags.limitRate, "limit-rate", "",
+		`Maximum upload and download rate in bytes per second, with an optional K, M or G suffix, example: "100K". Not limited by default`)
+	rootCmd.PersistentFlags().StringVar(&flags.unixSocket, "unix-socket", "",
+		"Connect to the Unix domain socket at the given path instead of the URL host. The URL host is still sent and signed")
+	rootCmd.PersistentFlags().StringVarP(&flags.proxyUser, "proxy-user", "U", "",
+		`User and password for the proxy Basic authentication, as "user:password". Takes precedence over the ones in the proxy URL`)
+	rootCmd.PersistentFlags().BoolVar(&flags.netrc, "netrc", false,
+		"Read the proxy user and password from ~/.netrc. The server is always authenticated with the AWS signature, not with Basic authentication")
+	rootCmd.PersistentFlags().StringVar(&flags.netrcFile, "netrc-file", "", "The same as --netrc, but read the given file instead of ~/.netrc")
+	rootCmd.PersistentFlags().StringVar(&flags.noProxy, "noproxy", "",
+		`Comma-separated list of hosts not to use the proxy for, overrides NO_PROXY. Example: --noproxy ".internal.example.com,10.0.0.0/8"`)
+	rootCmd.PersistentFlags().BoolVarP(&flags.location, "location", "L", false,
+		"Follow redirects, signing the redirected request again for the new location. By default, the 3xx response is returned as is")
+	rootCmd.PersistentFlags().IntVar(&flags.maxRedirs, "max-redirs", 50, "Maximum number of redirects to follow with --location, -1 for no limit")
+	rootCmd.PersistentFlags().BoolVar(&flags.post301, "post301", false,
+		"Keep the method and the body when following a 301 redirect with --location, instead of switching to GET")
+	rootCmd.PersistentFlags().BoolVar(&flags.post302, "post302", false,
+		"Keep the method and the body when following a 302 redirect with --location, instead of switching to GET")
+	rootCmd.PersistentFlags().BoolVar(&flags.post303, "post303", false,
+		"Keep the method and the body when following a 303 redirect with --location, instead of switching to GET")
+	rootCmd.PersistentFlags().BoolVar(&flags.failOnRedirect, "fail-on-redirect", false, "Don't follow redirects and fail if the server responds with any 3xx status")
+	rootCmd.PersistentFlags().StringVarP(&flags.output, "output", "o", "", `Write the response body to the given file instead of stdout ("-"). An existing file is overwritten`)
+	rootCmd.PersistentFlags().BoolVarP(&flags.silent, "silent", "s", false,
+		"Don't print the warnings, the progress and other diagnostics to stderr. The errors are still printed")
+	rootCmd.PersistentFlags().BoolVar(&flags.noClobber, "no-clobber", false, "Don't overwrite the existing --output file, fail instead")
+	rootCmd.PersistentFlags().StringVar(&flags.maxFileSize, "max-filesize", "",
+		`Fail if the response body is larger than the given number of bytes, with an optional K, M or G suffix, example: "10M"`)
+	rootCmd.PersistentFlags().StringVarP(&flags.byteRange, "range", "r", "", `Byte range to request, example: "0-1023", "1024-" or "-512" for the last 512 bytes`)
+	rootCmd.PersistentFlags().StringVarP(&flags.continueAt, "continue-at", "C", "",
+		`Resume the download from the given offset, appending the rest to the --output file. Use "-" to resume from the size of the file`)
+	rootCmd.PersistentFlags().BoolVar(&flags.exitStatus, "exit-status", false,
+		"Print the response as usual, then exit with the code mapped from the HTTP status: 0 for 2xx, 3 for 3xx, 4 for 4xx, 5 for 5xx and 1 for 1xx")
+	rootCmd.PersistentFlags().BoolVar(&flags.compressed, "compressed", false,
+		"Request the gzipped response with the signed Accept-Encoding header and decompress it")
+	rootCmd.PersistentFlags().BoolVar(&flags.pretty, "pretty", false, "Indent the JSON response body. The body which isn't a valid JSON is written as is")
+	rootCmd.PersistentFlags().BoolVar(&flags.autoDecompress, "auto-decompress", false,
+		"Decompress the response body if it's gzipped, detected by the content itself even without the Content-Encoding header")
+	rootCmd.PersistentFlags().StringVar(&flags.outputFilter, "output-filter", "",
+		`Pipe the response body through the given shell command before printing or saving it, example: --output-filter "jq ."`)
+	rootCmd.PersistentFlags().BoolVar(&flags.check, "check", false,
+		`Health check mode: print a single line "OK <status> <elapsed>" for a 2xx response or "FAIL <reason>" otherwise, and exit with 0 or 1`)
+	rootCmd.PersistentFlags().BoolVarP(&flags.progressBar, "progress-bar", "#", false,
+		"Show a progress bar on stderr while downloading the --output file, if the size is known and stderr is a terminal")
+	rootCmd.PersistentFlags().BoolVar(&flags.discard, "discard", false,
+		"Read the response body and discard it without printing or saving, e.g. for benchmarking. The same as -o "+os.DevNull)
+	rootCmd.PersistentFlags().StringVar(&flags.writeMetadata, "write-metadata", "",
+		"Write the response status and headers (including ETag) as JSON to the given file after a successful --output download")
+	rootCmd.PersistentFlags().BoolVar(&flags.verifyETag, "verify-etag", false,
+		"Verify the MD5 of the --output file matches the response ETag, the file is written only if it does. Multipart S3 objects are not verified")
+	rootCmd.PersistentFlags().BoolVar(&flags.noNewline, "no-newline", false, "Output the response body exactly as received, without appending a trailing newline")
+	rootCmd.PersistentFlags().BoolVarP(&flags.fail, "fail", "f", false, "Fail without printing the body if the server responds with 4xx or 5xx")
+	rootCmd.PersistentFlags().BoolVar(&flags.strict, "strict", false,
+		"Strict mode for scripting: print the body only for 2xx responses, otherwise print the AWS error and the request ID to stderr "+
+			"and exit with the code mapped from the status: 3 for 3xx, 4 for 4xx, 5 for 5xx")
+	rootCmd.PersistentFlags().BoolVar(&flags.abortOnAuthError, "abort-on-auth-error", false,
+		"Fail with the exit code 6 if the server responds with 401 or 403, telling an invalid signature or credentials from missing permissions")
+	rootCmd.PersistentFlags().BoolVar(&flags.abortOnEmptyBody, "abort-on-empty-body", false,
+		"Fail if the response body is empty, while it's expected: for any request but HEAD and any status but 1xx, 204 and 304")
+	rootCmd.PersistentFlags().StringVarP(&flags.writeOut, "write-out", "w", "",
+		`Print the given format to stdout after the response, with the variables like %{http_code}, %{time_total} or %{size_download} replaced, e.g. -w "%{http_code} %{time_total}\n"`)
+	rootCmd.PersistentFlags().StringVar(&flags.timingJSON, "timing-json", "",
+		"Write the request timings (dns_ms, connect_ms, tls_ms, ttfb_ms, total_ms) and the status code as JSON to the given file")
+	rootCmd.PersistentFlags().BoolVar(&flags.parseErrors, "parse-errors", false, `Print the AWS error code and message to stderr as "Code: Message" if the request fails`)
 
 	rootCmd.Flags().SortFlags = false
+
+	rootCmd.AddCommand(servicesCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(bulkCmd)
+	registerCompletions()
 }
 
 func runCurl(cmd *cobra.Command, args []string) error {
@@ -93,140 +409,971 @@ func runCurl(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Error: Only one URL is expected, %d given", len(args))
 	}
 
-	cfg, err := getAWSConfig(flags)
+	if flags.silent {
+		diagnostics = ioutil.Discard
+	}
+	// As in curl, "-o -" stands for stdout
+	if flags.output == "-" {
+		flags.output = ""
+	}
+
+	configs, err := newAWSConfigs(flags)
+	if err != nil {
+		return err
+	}
+
+	body, bodyContentType, err := buildBody(flags)
+	if err != nil {
+		return err
+	}
+	if flags.get && len(flags.form) > 0 {
+		return fmt.Errorf("Error: --form can't be used together with --get")
+	}
+
+	snippet := flags.snippet
+	if flags.dryRun {
+		if snippet != "" && snippet != "curl" {
+			return fmt.Errorf("Error: --dry-run prints a curl command, it can't be used together with --snippet %s", snippet)
+		}
+		snippet = "curl"
+	}
+
+	sigV4Region, sigV4Service, err := parseAWSSigV4(flags.awsSigV4)
 	if err != nil {
 		return err
 	}
+	signingDate, err := parseSigningDate(flags.date)
+	if err != nil {
+		return err
+	}
+
+	// Writing to /dev/null is handled the same as --discard, so the body is neither buffered nor written
+	discard := flags.discard || flags.output == os.DevNull
+	if flags.discard && flags.output != "" && flags.output != os.DevNull {
+		return fmt.Errorf("Error: --discard can't be used together with --output")
+	}
+	if discard && (flags.writeMetadata != "" || flags.verifyETag) {
+		return fmt.Errorf("Error: --write-metadata and --verify-etag can't be used when the response body is discarded")
+	}
+	if flags.sigV4A && (flags.presign || flags.dumpCanonical != "" || flags.dumpSigning) {
+		return fmt.Errorf("Error: --presign, --dump-canonical and --dump-signing are not supported with --sigv4a")
+	}
+	if flags.jsonOutput && (flags.output != "" || discard || flags.head || flags.include || flags.check) {
+		return fmt.Errorf("Error: --json-output can't be used together with --output, --discard, --head, --include and --check")
+	}
+	if flags.writeMetadata != "" && flags.output == "" {
+		return fmt.Errorf("Error: --write-metadata could be used only together with --output")
+	}
+	if flags.verifyETag && flags.output == "" {
+		return fmt.Errorf("Error: --verify-etag could be used only together with --output")
+	}
 
-	var body io.Reader
+	// Any method is allowed (e.g. PURGE or WebDAV ones), but it must be a valid token to be sent and signed correctly
+	method, err := normalizeMethod(flags.method)
+	if err != nil {
+		return err
+	}
+	// -I takes precedence over -X, and the empty payload is signed
+	if flags.head {
+		if body != nil {
+			return fmt.Errorf("Error: --head can't be used together with the data flags")
+		}
+		method = http.MethodHead
+	}
 
-	if strings.HasPrefix(flags.data, "@") {
-		// Read data from file
-		fPath := flags.data[1:]
-		body, err = os.Open(fPath)
+	// With -G the data is sent in the query instead of the body, the same as in curl
+	query := encodeQuery(flags.query)
+	if flags.get && body != nil {
+		data, err := ioutil.ReadAll(body)
 		if err != nil {
 			return err
 		}
-	} else {
-		body = strings.NewReader(flags.data)
+		if len(data) > 0 {
+			query = append(query, string(data))
+		}
+		body, bodyContentType = nil, ""
 	}
 
 	// Build the HTTP request
 	url := args[0]
-	req, err := http.NewRequest(flags.method, url, body)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	if len(query) > 0 {
+		if req.URL.RawQuery != "" {
+			query = append([]string{req.URL.RawQuery}, query...)
+		}
+		req.URL.RawQuery = strings.Join(query, "&")
+	}
+
+	headers, err := parseHeaders(flags.headers)
 	if err != nil {
 		return err
 	}
+	addHeaders(req.Header, headers)
 
-	for _, h := range flags.headers {
-		hParts := strings.SplitN(h, ":", 2)
-		if len(hParts) != 2 {
-			return fmt.Errorf(`Error: Invalid header: %s. It should be in the format "Name: Value"`, h)
+	// The header given with -H takes precedence. An empty --user-agent sends no header at all,
+	// while without the header Go would send its own default one.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		userAgent := "awscurl/" + version
+		if flagGiven(cmd, "user-agent") {
+			userAgent = flags.userAgent
 		}
-		hKey := strings.TrimSpace(hParts[0])
-		hVal := strings.TrimSpace(hParts[1])
-		req.Header.Add(hKey, hVal)
+		req.Header["User-Agent"] = []string{userAgent}
 	}
 
-	// Sign the HTTP request. Special headers will be added to the given *http.Request
-	reqBody := readAndReplaceBody(req)
-	reqBodySHA256 := hashSHA256(reqBody)
-	signer := v4.NewSigner()
+	// The cookies are signed as any other header, and they are set again for every redirect
+	var cookies *cookieJar
+	if len(flags.cookies) > 0 || flags.cookieJar != "" {
+		cookies = newCookieJar()
+		cookies.explicit = req.Header.Get("Cookie")
+		for _, c := range flags.cookies {
+			if err := cookies.add(c); err != nil {
+				return err
+			}
+		}
+		cookies.setHeader(req, true)
+	}
+
+	// Range is set before signing, as it could be signed as any other header
+	if flags.byteRange != "" && flags.continueAt != "" {
+		return fmt.Errorf("Error: Only one of --range and --continue-at could be used")
+	}
+	if flags.byteRange != "" {
+		if !byteRangePattern.MatchString(flags.byteRange) {
+			return fmt.Errorf(`Error: Invalid --range value: %s. It should be "start-end", "start-" or "-suffix", e.g. "0-1023"`, flags.byteRange)
+		}
+		req.Header.Set("Range", "bytes="+flags.byteRange)
+	}
+	var resumeOffset int64
+	if flags.continueAt != "" {
+		if flags.output == "" || flags.noClobber || flags.outputFilter != "" || flags.verifyETag {
+			return fmt.Errorf("Error: --continue-at could be used only together with --output, and without --no-clobber, --output-filter and --verify-etag")
+		}
+		if resumeOffset, err = parseContinueAt(flags.continueAt, flags.output); err != nil {
+			return err
+		}
+		if resumeOffset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeOffset))
+		}
+	}
+
+	// The header is signed, so it's added before signing. It takes effect only for a request with the body
+	if flags.expect100 && req.Header.Get("Expect") == "" {
+		req.Header.Set("Expect", "100-continue")
+	}
+
+	// Unlike the one added by the transport, this header is signed
+	if flags.compressed && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 
-	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if flags.traceID || flags.traceIDValue != "" {
+		traceID := flags.traceIDValue
+		if traceID == "" {
+			traceID, err = newUUID()
+			if err != nil {
+				return err
+			}
+		}
+		req.Header.Set(flags.traceIDHeader, traceID)
+		fmt.Fprintf(diagnostics, "%s: %s\n", flags.traceIDHeader, traceID)
+	}
+
+	// As any other x-amz-* header, it's always included to the signature, which S3 requires
+	if flags.requestPayer {
+		req.Header.Set(requestPayerHeader, "requester")
+	}
+
+	cfg, err := configs.forHost(req.URL.Hostname())
 	if err != nil {
 		return err
 	}
 
-	err = signer.SignHTTP(req.Context(), creds, req, reqBodySHA256, flags.awsService, cfg.Region, time.Now())
-	if err != nil {
+	service, region := resolveService(cmd, req.URL.Hostname(), sigV4Service, sigV4Region)
+	if region != "" {
+		cfg.Region = region
+	}
+	signingName := service
+	if flags.signingName != "" {
+		signingName = flags.signingName
+	}
+
+	// Large files are streamed to S3 with the aws-chunked encoding rather than read in memory to be hashed,
+	// unless the request is signed in any special way or written out instead of being sent
+	streamFile, streamSize, streaming := streamableFile(req.Body)
+	streaming = streaming && signingName == "s3" && !flags.sigV4A && !flags.presign && snippet == "" && !flags.echo &&
+		flags.dumpCanonical == "" && !flags.dumpSigning && !flags.noContentSHA256 && strings.EqualFold(flags.dateHeader, amzDateHeader)
+
+	// Sign the HTTP request. Special headers will be added to the given *http.Request
+	var reqBody []byte
+	if streaming {
+		prepareStreamingBody(req, streamFile, streamSize)
+	} else if reqBody, err = readAndReplaceBody(req); err != nil {
 		return err
 	}
 
-	// Set TLS Client configuration
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: flags.insecure},
+	// Set the default content type of the service API, unless it's set explicitly with -H.
+	// The URL-encoded data and the multipart form are always forms, whatever the service API accepts.
+	if bodyContentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", bodyContentType)
+	}
+	if known, ok := serviceByName(service); ok && known.ContentType != "" && (len(reqBody) > 0 || streaming) && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", known.ContentType)
+	}
+	signer, creds, reqBodySHA256, err := newRequestSigner(cfg, signingName, signingDate, req, reqBody, streaming)
+	if err != nil {
+		return err
 	}
 
-	// Add proxy settings if needed
-	if flags.proxy != "" {
-		// Parse *urls.URL from the given string
-		proxyURL, err := urls.Parse(flags.proxy)
+	if flags.presign {
+		presignedURL, err := presign(signer, req, reqBodySHA256, flags.expires)
 		if err != nil {
 			return err
 		}
+		fmt.Println(presignedURL)
+		return nil
+	}
 
-		tr.Proxy = http.ProxyURL(proxyURL)
+	signingTime, err := signer.Sign(req, reqBodySHA256)
+	if err != nil {
+		return err
 	}
 
-	// Send the request and print the response
-	client := http.Client{Transport: tr}
-	response, err := client.Do(req)
+	if flags.dumpCanonical != "" {
+		if err := dumpCanonical(flags.dumpCanonical, creds, req, reqBodySHA256, signingName, cfg.Region, signingTime); err != nil {
+			return err
+		}
+	}
+	if flags.dumpSigning {
+		if err := awscurl.WriteSigningDetails(os.Stderr, creds, req, reqBodySHA256, signingName, cfg.Region, signingTime); err != nil {
+			return err
+		}
+	}
+
+	if flags.verbose {
+		writeVerboseRequest(os.Stderr, req, reqBodySHA256)
+		if creds.CanExpire {
+			fmt.Fprintf(os.Stderr, "* Credentials expire at %s (in %s)\n", creds.Expires.UTC().Format(time.RFC3339), time.Until(creds.Expires).Round(time.Second))
+		}
+	}
+
+	if flags.echo {
+		return req.Write(os.Stdout)
+	}
+	if snippet != "" {
+		return writeSnippet(os.Stdout, snippet, req, reqBody)
+	}
+
+	tr, transport, err := newTransport(cmd)
 	if err != nil {
 		return err
 	}
+	// The body is sent once the server responds with 100 Continue, or once the timeout is over without any response
+	if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+		tr.ExpectContinueTimeout = flags.expect100Timeout
+	}
+	if flags.trace != "" && flags.traceASCII != "" {
+		return fmt.Errorf("Error: Only one of --trace and --trace-ascii could be used")
+	}
+	if traceFile := flags.trace + flags.traceASCII; traceFile != "" {
+		var w io.Writer = os.Stdout
+		if traceFile != "-" {
+			f, err := os.Create(traceFile)
+			if err != nil {
+				return fmt.Errorf("Error: Unable to create the trace file: %s", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		transport = &tracingTransport{next: transport, w: w, ascii: flags.traceASCII != "", redact: flags.traceRedact}
+	}
+	// The chunks are encoded on top of the tracing, so the traced request is the one actually sent
+	if streaming {
+		transport = &awsChunkedTransport{next: transport, creds: creds}
+	}
+
+	if (flags.post301 || flags.post302 || flags.post303) && !flags.location {
+		return fmt.Errorf("Error: --post301, --post302 and --post303 could be used only together with --location")
+	}
+	keepMethod := map[int]bool{
+		http.StatusMovedPermanently: flags.post301,
+		http.StatusFound:            flags.post302,
+		http.StatusSeeOther:         flags.post303,
+	}
+
+	// Send the request and print the response
+	client := http.Client{
+		Transport:     transport,
+		CheckRedirect: redirectPolicy(flags.location && !flags.failOnRedirect, flags.maxRedirs, keepMethod, cookies, signer, reqBodySHA256),
+	}
+	// --max-time bounds the whole operation, including the retries and reading the body
+	ctx := req.Context()
+	if flags.maxTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.maxTime)
+		defer cancel()
+	}
+	timings := &requestTimings{}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, timings.clientTrace()))
+
+	response, err := newRetrier().do(&client, req, reqBodySHA256, signer, timings)
+	if err != nil {
+		return explainTimeout(ctx, err, flags.connectTimeout, flags.maxTime)
+	}
 	defer response.Body.Close()
 
+	if flags.verbose {
+		writeVerboseResponse(os.Stderr, response)
+	}
+	// Without HTTP/2 in ALPN, the server falls back to HTTP/1.1
+	if flags.http2 && response.ProtoMajor != 2 {
+		return fmt.Errorf("Error: The server doesn't support HTTP/2, it responded with %s", response.Proto)
+	}
+
+	if cookies != nil {
+		cookies.setCookies(response.Request.URL, response.Cookies())
+		if flags.cookieJar != "" {
+			if err := cookies.save(flags.cookieJar); err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags.failOnRedirect && response.StatusCode >= 300 && response.StatusCode < 400 {
+		return fmt.Errorf("Error: The server responded with a redirect: %s, Location: %s", response.Status, response.Header.Get("Location"))
+	}
+
+	// The progress is shown only if it could be rendered meaningfully: the size is known and stderr is a terminal
+	var responseBody io.Reader = response.Body
+	var progress *progressBar
+	if flags.progressBar && !flags.silent && flags.output != "" && response.ContentLength > 0 && isTerminal(os.Stderr) {
+		progress = newProgressBar(os.Stderr, response.ContentLength)
+		responseBody = io.TeeReader(response.Body, progress)
+	}
+
+	if flags.dumpHeader != "" {
+		if err := dumpResponseHeaders(flags.dumpHeader, response); err != nil {
+			return err
+		}
+	}
+
+	// The body isn't read at all if it's known to be too large, and it's still limited while reading otherwise
+	if flags.maxFileSize != "" {
+		maxSize, ok := parseByteSize(flags.maxFileSize)
+		if !ok {
+			return fmt.Errorf(`Error: Invalid --max-filesize value: %s. It should be a positive number of bytes with an optional K, M or G suffix, e.g. "10M"`, flags.maxFileSize)
+		}
+		if response.ContentLength > maxSize {
+			return fmt.Errorf("Error: The response body of %d bytes exceeds --max-filesize of %d bytes", response.ContentLength, maxSize)
+		}
+		responseBody = &maxSizeReader{r: responseBody, max: maxSize}
+	}
+
+	// Only the missing part could be appended, anything else would corrupt the partially downloaded file
+	if flags.continueAt != "" {
+		switch {
+		case response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+			return fmt.Errorf("Error: Unable to resume the download from %d: %s. The file %s could be complete already", resumeOffset, response.Status, flags.output)
+		case response.StatusCode >= 300 || (resumeOffset > 0 && response.StatusCode != http.StatusPartialContent):
+			return fmt.Errorf("Error: Unable to resume the download from %d, the server responded with %s", resumeOffset, response.Status)
+		}
+	}
+
+	// Accept-Encoding is set explicitly with --compressed, so the transport leaves the response compressed.
+	// The server could ignore it and send the response as is, then it's not decompressed.
+	if flags.compressed && strings.EqualFold(response.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(responseBody)
+		switch {
+		case err == io.EOF:
+			// The response has no body, e.g. for HEAD
+		case err != nil:
+			return fmt.Errorf("Error: Unable to decompress the gzipped response: %s", err)
+		default:
+			responseBody = gz
+		}
+	}
+
+	// A successful response saved to a file is streamed, so large objects aren't buffered in memory.
+	// The discarded body is only counted. Any other body is read completely, e.g. to parse the AWS error below.
+	stream := flags.output != "" && !discard && !flags.check && !flags.head && response.StatusCode < 400 &&
+		!(flags.strict && (response.StatusCode < 200 || response.StatusCode >= 300))
+	outputOpts := outputOptions{
+		autoDecompress: flags.autoDecompress,
+		filter:         flags.outputFilter,
+		verifyETag:     flags.verifyETag,
+		prettyJSON:     flags.pretty && isJSONContentType(response.Header.Get("Content-Type")),
+		appendFile:     flags.continueAt != "",
+	}
+
 	var content []byte
-	content, err = ioutil.ReadAll(response.Body)
+	var downloaded int64
+	switch {
+	case stream:
+		if flags.include {
+			printResponseHeaders(os.Stdout, response)
+		}
+		downloaded, err = writeOutputFile(flags.output, flags.noClobber, responseBody, response.Header.Get("ETag"), outputOpts)
+	case discard && response.StatusCode < 400:
+		downloaded, err = io.Copy(ioutil.Discard, responseBody)
+	default:
+		content, err = ioutil.ReadAll(responseBody)
+		downloaded = int64(len(content))
+	}
+	if progress != nil {
+		progress.finish()
+	}
 	if err != nil {
+		return explainTimeout(ctx, err, flags.connectTimeout, flags.maxTime)
+	}
+	timings.done = time.Now()
+
+	// It's printed after the response body, even if awscurl then fails because of the response status
+	if flags.writeOut != "" {
+		defer fmt.Print(formatWriteOut(flags.writeOut, timings, response, downloaded))
+	}
+
+	if flags.timingJSON != "" {
+		if err := writeTimingJSON(flags.timingJSON, timings, response.StatusCode, downloaded); err != nil {
+			return err
+		}
+	}
+
+	if flags.check {
+		return checkResponse(response, content, timings)
+	}
+
+	if (flags.parseErrors || flags.strict) && response.StatusCode >= 400 {
+		if awsErr, ok := parseAWSError(response.Header, content); ok {
+			fmt.Fprintln(os.Stderr, awsErr)
+		}
+	}
+
+	if flags.abortOnAuthError && (response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden) {
+		return newAuthError(response, content)
+	}
+
+	// --strict goes first, so its exit code isn't lost when -f is given too
+	if flags.strict && (response.StatusCode < 200 || response.StatusCode >= 300) {
+		return newStatusError(response)
+	}
+
+	if flags.fail && response.StatusCode >= 400 {
+		return fmt.Errorf("Error: The server responded with %s", response.Status)
+	}
+
+	if flags.abortOnEmptyBody && downloaded == 0 && bodyExpected(req.Method, response.StatusCode) {
+		return emptyBodyError(response)
+	}
+
+	// Unlike --strict, --exit-status fails only once the response is written out as usual
+	exitStatus := func(err error) error {
+		if err == nil && flags.exitStatus && (response.StatusCode < 200 || response.StatusCode >= 300) {
+			return newStatusError(response)
+		}
 		return err
 	}
 
-	if flags.include {
-		fmt.Printf("%s %d\n", response.Proto, response.StatusCode)
+	if discard {
+		return exitStatus(nil)
+	}
 
-		for header := range response.Header {
-			for _, value := range response.Header.Values(header) {
-				fmt.Printf("%s: %s\n", header, value)
-			}
+	if flags.jsonOutput {
+		return exitStatus(writeJSONResponse(os.Stdout, response, content, timings))
+	}
+
+	// There is no body in the response to HEAD, so only the headers are written, either to stdout or to the file
+	if flags.head {
+		var headers bytes.Buffer
+		printResponseHeaders(&headers, response)
+		if flags.output == "" {
+			_, err := os.Stdout.Write(headers.Bytes())
+			return exitStatus(err)
 		}
+		_, err := writeOutputFile(flags.output, flags.noClobber, &headers, "", outputOptions{})
+		return exitStatus(err)
+	}
+
+	if !stream && flags.include {
+		printResponseHeaders(os.Stdout, response)
+	}
 
-		fmt.Print("\n")
+	if flags.output != "" {
+		if !stream {
+			_, err := writeOutputFile(flags.output, flags.noClobber, bytes.NewReader(content), response.Header.Get("ETag"), outputOpts)
+			if err != nil {
+				return err
+			}
+		}
+		if flags.writeMetadata != "" {
+			return exitStatus(writeMetadataFile(flags.writeMetadata, response))
+		}
+		return exitStatus(nil)
 	}
 
-	fmt.Println(string(content))
+	var output bytes.Buffer
+	if _, err := writeBody(&output, bytes.NewReader(content), response.Header.Get("ETag"), outputOpts); err != nil {
+		return err
+	}
+	// The output of --output-filter is relayed as is, only the body itself gets the trailing newline
+	if flags.noNewline || flags.outputFilter != "" {
+		_, err = os.Stdout.Write(output.Bytes())
+		return exitStatus(err)
+	}
+	fmt.Println(output.String())
 
-	return nil
+	return exitStatus(nil)
 }
 
-// getAWSConfig builgs the AWS Config based on the provided AWS-related flags
+// getAWSConfig builds the AWS Config based on the provided AWS-related flags.
+// The credentials are cached, so the temporary ones are refreshed only when they are about to expire.
 func getAWSConfig(f awsCURLFlags) (aws.Config, error) {
-	var cfg aws.Config
-	var cfgSources []func(*config.LoadOptions) error
+	cfg, err := loadAWSConfig(f)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Credentials = cacheCredentials(cfg.Credentials)
+	return cfg, nil
+}
 
-	if f.awsProfile != "" {
-		awsProfileLoader := config.WithSharedConfigProfile(f.awsProfile)
-		cfgSources = append(cfgSources, awsProfileLoader)
+// loadAWSConfig loads the AWS Config and sets up the credentials provider chosen with the flags
+func loadAWSConfig(f awsCURLFlags) (aws.Config, error) {
+	var cfgSources []func(*config.LoadOptions) error
+	if f.imdsEndpoint != "" {
+		cfgSources = append(cfgSources, config.WithEC2IMDSEndpoint(f.imdsEndpoint))
 	}
-	if f.awsAccessKey != "" && f.awsSecretKey != "" {
-		staticCredsLoader := config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(f.awsAccessKey, f.awsSecretKey, f.awsSessionToken))
-		cfgSources = append(cfgSources, staticCredsLoader)
+
+	if err := checkSessionToken(f.awsAccessKey, f.awsSecretKey, f.awsSessionToken); err != nil {
+		return aws.Config{}, err
 	}
 
-	cfg, err := config.LoadDefaultConfig(context.Background(), cfgSources...)
+	cfg, err := awscurl.LoadConfig(context.Background(), awscurl.Options{
+		Profile:      f.awsProfile,
+		AccessKey:    f.awsAccessKey,
+		SecretKey:    f.awsSecretKey,
+		SessionToken: f.awsSessionToken,
+		Region:       f.awsRegion,
+	}, cfgSources...)
 	if err != nil {
 		return cfg, fmt.Errorf("Unable to load AWS config: %s", err)
 	}
+	// The SDK reads only AWS_REGION, while the AWS CLI falls back to AWS_DEFAULT_REGION before the profile region
+	if env := os.Getenv("AWS_DEFAULT_REGION"); env != "" && f.awsRegion == "" && os.Getenv("AWS_REGION") == "" {
+		cfg.Region = env
+	}
+
+	// Most often the SSO credentials fail because the SSO session has expired, it's worth telling how to fix it
+	profile := sharedConfigProfile(f.awsProfile)
+	if sharedCfg, err := loadSharedConfigProfile(context.Background(), profile); err == nil && (sharedCfg.SSOSessionName != "" || sharedCfg.SSOStartURL != "") {
+		cfg.Credentials = &ssoProvider{provider: cfg.Credentials, profile: profile}
+	}
+
+	if f.instanceProfile {
+		if f.awsProfile != "" || f.awsAccessKey != "" {
+			return cfg, fmt.Errorf("Error: --instance-profile can't be used together with --profile or --access-key")
+		}
+		cfg.Credentials = newInstanceProfileProvider(f.imdsEndpoint)
+	}
+
+	// The role is assumed with the token instead of the credentials resolved above
+	if f.webIdentityTokenFile != "" {
+		if f.instanceProfile {
+			return cfg, fmt.Errorf("Error: --web-identity-token-file can't be used together with --instance-profile")
+		}
+		roleARN := f.roleARN
+		if roleARN == "" {
+			roleARN = os.Getenv("AWS_ROLE_ARN")
+		}
+		if roleARN == "" {
+			return cfg, fmt.Errorf("Error: --web-identity-token-file requires the role to assume, set it with --role-arn or AWS_ROLE_ARN")
+		}
+		cfg.Credentials = newWebIdentityProvider(cfg, roleARN, f.webIdentityTokenFile, f.roleSessionName)
+		return cfg, nil
+	}
+
+	if (f.serialNumber == "") != (f.tokenCode == "") {
+		return cfg, fmt.Errorf("Error: --serial-number and --token-code could be used only together")
+	}
+	mfa := mfaDevice{serialNumber: f.serialNumber, tokenCode: f.tokenCode}
+
+	// The credentials resolved above are used as the base ones to assume the role.
+	// Without the role, MFA gets the session token for the base credentials themselves.
+	switch {
+	case f.roleARN != "":
+		cfg.Credentials = newAssumeRoleProvider(cfg, f.roleARN, f.roleSessionName, f.externalID, mfa)
+	case mfa.serialNumber != "":
+		cfg.Credentials = newSessionTokenProvider(cfg, mfa)
+	}
+
+	return cfg, nil
+}
+
+// awsConfigs lazily loads and caches the AWS Config for each profile mapped with --host-profile-map
+type awsConfigs struct {
+	flags        awsCURLFlags
+	hostProfiles map[string]string
+	cache        map[string]aws.Config
+}
+
+func newAWSConfigs(f awsCURLFlags) (*awsConfigs, error) {
+	hostProfiles := make(map[string]string)
+	for _, m := range f.hostProfileMap {
+		mParts := strings.SplitN(m, "=", 2)
+		if len(mParts) != 2 || mParts[0] == "" || mParts[1] == "" {
+			return nil, fmt.Errorf(`Error: Invalid host profile mapping: %s. It should be in the format "host=profile"`, m)
+		}
+		hostProfiles[strings.ToLower(mParts[0])] = mParts[1]
+	}
+
+	return &awsConfigs{flags: f, hostProfiles: hostProfiles, cache: make(map[string]aws.Config)}, nil
+}
+
+// forHost returns the AWS Config for the given host.
+// The mapped profile takes precedence over the credentials given with flags, unmapped hosts get the default config.
+func (c *awsConfigs) forHost(host string) (aws.Config, error) {
+	profile, mapped := c.hostProfiles[strings.ToLower(host)]
+	if cfg, ok := c.cache[profile]; ok {
+		return cfg, nil
+	}
+
+	f := c.flags
+	if mapped {
+		f.awsProfile = profile
+		f.awsAccessKey, f.awsSecretKey, f.awsSessionToken = "", "", ""
+	}
 
-	if f.awsRegion != "" {
-		cfg.Region = f.awsRegion
+	cfg, err := getAWSConfig(f)
+	if err != nil {
+		return cfg, err
 	}
+	c.cache[profile] = cfg
 
 	return cfg, nil
 }
 
-func readAndReplaceBody(request *http.Request) []byte {
+// dumpCanonical writes the signing details of the request to the file at the given path
+func dumpCanonical(path string, creds aws.Credentials, req *http.Request, payloadHash, service, region string, signingTime time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return awscurl.WriteSigningDetails(f, creds, req, payloadHash, service, region, signingTime)
+}
+
+// buildBody returns the request body from the data flags, along with the content type implied by them, if any
+func buildBody(f awsCURLFlags) (io.Reader, string, error) {
+	given := 0
+	for _, d := range []string{f.dataASCII, f.dataBinary, f.dataBase64} {
+		if d != "" {
+			given++
+		}
+	}
+	// -d and --data-urlencode parts are joined together, as in curl
+	if len(f.data) > 0 || len(f.dataURLEncode) > 0 {
+		given++
+	}
+	if len(f.form) > 0 {
+		given++
+	}
+	if given > 1 {
+		return nil, "", fmt.Errorf("Error: Only one of --data (with --data-urlencode), --data-ascii, --data-binary, --data-base64 and --form could be used")
+	}
+
+	switch {
+	case f.dataBase64 != "":
+		payload, err := base64.StdEncoding.DecodeString(f.dataBase64)
+		if err != nil {
+			return nil, "", fmt.Errorf("Error: Invalid base64 data: %s", err)
+		}
+		return bytes.NewReader(payload), "", nil
+	case f.dataASCII != "":
+		r, err := dataReader(f.dataASCII, f.decompressInput)
+		if err != nil {
+			return nil, "", err
+		}
+		payload, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, "", err
+		}
+		return strings.NewReader(strings.NewReplacer("\r", "", "\n", "").Replace(string(payload))), "", nil
+	case f.dataBinary != "":
+		r, err := dataReader(f.dataBinary, f.decompressInput)
+		return r, "", err
+	case len(f.data) == 1 && len(f.dataURLEncode) == 0:
+		r, err := dataReader(f.data[0], f.decompressInput)
+		return r, "", err
+	case len(f.form) > 0:
+		return buildMultipartForm(f.form)
+	}
+
+	var parts []string
+	for _, d := range f.data {
+		r, err := dataReader(d, f.decompressInput)
+		if err != nil {
+			return nil, "", err
+		}
+		part, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, "", err
+		}
+		parts = append(parts, string(part))
+	}
+	for _, d := range f.dataURLEncode {
+		part, err := urlEncodeData(d)
+		if err != nil {
+			return nil, "", err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return nil, "", nil
+	}
+	var contentType string
+	if len(f.dataURLEncode) > 0 {
+		contentType = contentTypeQuery
+	}
+	return strings.NewReader(strings.Join(parts, "&")), contentType, nil
+}
+
+// urlEncodeData encodes a --data-urlencode value the same way as curl does:
+// "content" and "=content" encode the content, "name=content" encodes only the content,
+// "@file" and "name@file" encode the content of the file. The name is expected to be encoded already.
+func urlEncodeData(data string) (string, error) {
+	name, content := "", data
+	if i := strings.IndexAny(data, "=@"); i >= 0 {
+		name, content = data[:i], data[i+1:]
+		if data[i] == '@' {
+			f, err := openDataFile(content, false)
+			if err != nil {
+				return "", err
+			}
+			b, err := ioutil.ReadAll(f)
+			if err != nil {
+				return "", err
+			}
+			content = string(b)
+		}
+	}
+
+	// Spaces are encoded as %20 rather than "+", as curl does
+	encoded := strings.Replace(urls.QueryEscape(content), "+", "%20", -1)
+	if name == "" {
+		return encoded, nil
+	}
+	return name + "=" + encoded, nil
+}
+
+// encodeQuery encodes the --query parameters given as "name=value" (or "name" for an empty value).
+// The parameters with the same name are all kept, in the given order.
+func encodeQuery(params []string) []string {
+	escape := func(s string) string {
+		return strings.Replace(urls.QueryEscape(s), "+", "%20", -1)
+	}
+
+	var encoded []string
+	for _, p := range params {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) == 1 {
+			encoded = append(encoded, escape(parts[0]))
+		} else {
+			encoded = append(encoded, escape(parts[0])+"="+escape(parts[1]))
+		}
+	}
+	return encoded
+}
+
+// dataReader returns the reader of the data given inline or, if prefixed with @, read from the file
+func dataReader(data string, decompress bool) (io.Reader, error) {
+	if strings.HasPrefix(data, "@") {
+		return openDataFile(data[1:], decompress)
+	}
+	return strings.NewReader(data), nil
+}
+
+// openDataFile opens the file to read the request payload from, gunzipping its content if needed.
+// The path "-" stands for stdin, as in curl.
+func openDataFile(path string, decompress bool) (io.Reader, error) {
+	var f io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		f = file
+	}
+	if !decompress {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to decompress %s: %s", path, err)
+	}
+	return gz, nil
+}
+
+// byteRangePattern matches the --range values, e.g. "0-1023", "1024-", "-512" or "0-99,200-299"
+var byteRangePattern = regexp.MustCompile(`^(\d+-\d*|-\d+)(,(\d+-\d*|-\d+))*$`)
+
+// parseContinueAt returns the offset to resume the download from. "-" stands for the size of the output file,
+// which is 0 if the file doesn't exist yet.
+func parseContinueAt(value, output string) (int64, error) {
+	if value == "-" {
+		info, err := os.Stat(output)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf(`Error: Invalid --continue-at value: %s. It should be a non-negative offset in bytes or "-"`, value)
+	}
+	return offset, nil
+}
+
+// normalizeMethod trims and uppercases the request method, so "-X get " is sent and signed as "GET".
+// The method must be a valid token as defined in RFC 7230.
+func normalizeMethod(method string) (string, error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	if method == "" {
+		return "", fmt.Errorf("Error: The request method must not be empty")
+	}
+	for _, c := range method {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c)) {
+			return "", fmt.Errorf("Error: Invalid request method: %q. It contains invalid character %q", method, c)
+		}
+	}
+	return method, nil
+}
+
+// parseAWSSigV4 returns the region and the service from the curl --aws-sigv4 value "aws:amz[:region[:service]]".
+// Both are empty if they are not given, so they are detected by the URL host.
+func parseAWSSigV4(value string) (string, string, error) {
+	if value == "" {
+		return "", "", nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return "", "", fmt.Errorf(`Error: Invalid --aws-sigv4 value: %s. It should be in the format "aws:amz[:region[:service]]", e.g. "aws:amz:us-east-1:es"`, value)
+	}
+	if !strings.EqualFold(parts[0], "aws") || !strings.EqualFold(parts[1], "amz") {
+		return "", "", fmt.Errorf(`Error: Unsupported --aws-sigv4 provider: %s:%s. Only "aws:amz" is supported`, parts[0], parts[1])
+	}
+
+	parts = append(parts, "", "")
+	return parts[2], parts[3], nil
+}
+
+// parseHeaders parses the -H values. A single value could contain several headers separated with "\n",
+// or they could be read from the file given as "@file".
+func parseHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, h := range values {
+		lines := strings.Split(strings.Replace(h, `\n`, "\n", -1), "\n")
+		if strings.HasPrefix(h, "@") {
+			var err error
+			if lines, err = readHeadersFile(h[1:]); err != nil {
+				return nil, err
+			}
+		}
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			name, value, err := parseHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			headers.Add(name, value)
+		}
+	}
+	return headers, nil
+}
+
+// addHeaders adds the headers to the request ones, keeping the order of the repeated headers
+func addHeaders(dst, headers http.Header) {
+	for name, values := range headers {
+		for _, value := range values {
+			dst.Add(name, value)
+		}
+	}
+}
+
+// readHeadersFile reads the "Name: Value" headers from the file, one per line.
+// The blank lines and the comments starting with "#" are skipped.
+func readHeadersFile(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to read the headers file: %s", err)
+	}
+
+	var headers []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, nil
+}
+
+// parseHeader splits the header in the format "Name: Value" to the name and the value.
+// Only the first colon separates the name, so the value could contain colons, e.g. "X-Target: a:b:c".
+// The leading whitespace of the value is optional (RFC 7230, section 3.2) and is trimmed.
+// As in curl, "Name;" stands for the header with an empty value.
+func parseHeader(h string) (string, string, error) {
+	name, value, ok := awscurl.ParseHeader(h)
+	if !ok {
+		return "", "", fmt.Errorf(`Error: Invalid header: %s. It should be in the format "Name: Value", or "Name;" for an empty value`, h)
+	}
+	return name, value, nil
+}
+
+func readAndReplaceBody(request *http.Request) ([]byte, error) {
 	if request.Body == nil {
-		return []byte{}
+		return []byte{}, nil
+	}
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		// An empty file must not be sent as a chunked body
+		request.Body = http.NoBody
+		request.GetBody = nil
+		return payload, nil
 	}
-	payload, _ := ioutil.ReadAll(request.Body)
 	request.Body = ioutil.NopCloser(bytes.NewReader(payload))
-	return payload
+	// The length is unknown for a file or stdin until it's read, so the request would be sent chunked otherwise
+	request.ContentLength = int64(len(payload))
+	// The body is re-read when the request is redirected with -L
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(payload)), nil
+	}
+	return payload, nil
 }
 
 func hashSHA256(content []byte) string {
-	h := sha256.New()
-	h.Write(content)
-	return fmt.Sprintf("%x", h.Sum(nil))
+	return awscurl.HashSHA256(content)
+}
+
+// newUUID generates a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
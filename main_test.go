@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/spf13/pflag"
+)
+
+// testCredentials are set in the environment by runAwscurl
+var testCredentials = aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+// runAwscurl runs the command with the given arguments and returns what it writes to stdout.
+// The flags are reset to their defaults first, and the environment has only the test credentials and region.
+func runAwscurl(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	return runAwscurlEnv(t, nil, args...)
+}
+
+// runAwscurlEnv is runAwscurl with the given environment variables set on top of the test ones.
+func runAwscurlEnv(t *testing.T, env map[string]string, args ...string) (string, error) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(home, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(home, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", testCredentials.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", testCredentials.SecretAccessKey)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	for _, name := range []string{"AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_REGION", "AWSCURL_SERVICE", "AWS_CA_BUNDLE",
+		"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	resetFlags(t)
+	diagnostics = ioutil.Discard
+	defer func() { diagnostics = os.Stderr }()
+
+	stdout, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	realStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = realStdout }()
+
+	rootCmd.SetArgs(args)
+	rootCmd.SetErr(ioutil.Discard)
+	runErr := rootCmd.Execute()
+
+	output, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), runErr
+}
+
+// resetFlags sets all the flags of the commands back to their defaults, as if they are not given
+func resetFlags(t *testing.T) {
+	t.Helper()
+	configFlags = map[string]bool{}
+	reset := func(f *pflag.Flag) {
+		// pflag.SliceValue.Replace replaces the whole list, while Set would append to it
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(nil); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := f.Value.Set(f.DefValue); err != nil {
+			t.Fatalf("unable to reset --%s: %s", f.Name, err)
+		}
+		f.Changed = false
+	}
+	for _, cmd := range append(rootCmd.Commands(), rootCmd) {
+		cmd.PersistentFlags().VisitAll(reset)
+		cmd.Flags().VisitAll(reset)
+	}
+}
+
+// signedRequest is the request received by the sigV4Verifier
+type signedRequest struct {
+	*http.Request
+	body []byte
+	// signatureErr tells why the signature is invalid, it's empty for the valid one
+	signatureErr string
+}
+
+// sigV4Verifier is the test server verifying the SigV4 signature of every request, the same way as AWS does:
+// the request is signed again with the same signing time and the secret key, and the signatures are compared.
+// The requests with an invalid signature get 403.
+type sigV4Verifier struct {
+	*httptest.Server
+	creds aws.Credentials
+
+	mu       sync.Mutex
+	requests []signedRequest
+	// handler writes the response for the request with the valid signature, it's 200 with "OK" by default
+	handler http.HandlerFunc
+}
+
+func newSigV4Verifier(t *testing.T, creds aws.Credentials) *sigV4Verifier {
+	v := &sigV4Verifier{creds: creds}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serveHTTP))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *sigV4Verifier) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	received := signedRequest{Request: r, body: body, signatureErr: v.verify(r, body)}
+	v.mu.Lock()
+	v.requests = append(v.requests, received)
+	handler := v.handler
+	v.mu.Unlock()
+
+	if received.signatureErr != "" {
+		http.Error(w, received.signatureErr, http.StatusForbidden)
+		return
+	}
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	w.Write([]byte("OK"))
+}
+
+// verify returns the reason why the signature of the request is invalid, or the empty string for the valid one
+func (v *sigV4Verifier) verify(r *http.Request, body []byte) string {
+	authorization := r.Header.Get("Authorization")
+	signature, scope, ok := parseAuthorization(authorization)
+	if !ok {
+		return "the request isn't signed"
+	}
+	scopeParts := strings.Split(scope, "/")
+	if len(scopeParts) != 4 || !strings.Contains(authorization, "Credential="+v.creds.AccessKeyID+"/") {
+		return "invalid credential " + authorization
+	}
+	signingTime, err := time.Parse(amzDateFormat, r.Header.Get(amzDateHeader))
+	if err != nil {
+		return "invalid " + amzDateHeader
+	}
+	if v.creds.SessionToken != "" && r.Header.Get("X-Amz-Security-Token") != v.creds.SessionToken {
+		return "invalid security token"
+	}
+
+	// Only the signed headers are signed again, as the ones added on the way (e.g. by a proxy) are not covered
+	signedHeaders := ""
+	for _, part := range strings.Split(authorization, ",") {
+		if part = strings.TrimSpace(part); strings.HasPrefix(part, "SignedHeaders=") {
+			signedHeaders = strings.TrimPrefix(part, "SignedHeaders=")
+		}
+	}
+	expected, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	for _, name := range strings.Split(signedHeaders, ";") {
+		switch name {
+		case "host":
+		case "content-length":
+			expected.ContentLength = r.ContentLength
+		default:
+			expected.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+	expected.Header.Del(amzDateHeader)
+	expected.Header.Del("X-Amz-Security-Token")
+
+	payloadHash := r.Header.Get(contentSHA256Header)
+	switch {
+	case payloadHash == "":
+		payloadHash = hashSHA256(body)
+	case payloadHash != unsignedPayload && payloadHash != hashSHA256(body):
+		return "the payload doesn't match " + contentSHA256Header
+	}
+	err = v4.NewSigner().SignHTTP(context.Background(), v.creds, expected, payloadHash, scopeParts[2], scopeParts[1], signingTime)
+	if err != nil {
+		return err.Error()
+	}
+	if expectedSignature, _, _ := parseAuthorization(expected.Header.Get("Authorization")); signature != expectedSignature {
+		return "the signature doesn't match"
+	}
+	return ""
+}
+
+// parseAuthorization returns the signature and the credential scope of the SigV4 Authorization header
+func parseAuthorization(authorization string) (signature, scope string, ok bool) {
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		return "", "", false
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case strings.HasPrefix(part, "Credential="):
+			if i := strings.Index(part, "/"); i >= 0 {
+				scope = part[i+1:]
+			}
+		case strings.HasPrefix(part, "Signature="):
+			signature = strings.TrimPrefix(part, "Signature=")
+		}
+	}
+	return signature, scope, signature != "" && scope != ""
+}
+
+// lastRequest returns the last request received by the server
+func (v *sigV4Verifier) lastRequest(t *testing.T) signedRequest {
+	t.Helper()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.requests) == 0 {
+		t.Fatal("the server has received no requests")
+	}
+	return v.requests[len(v.requests)-1]
+}
+
+// lastSignedRequest returns the last request received by the server, failing the test if its signature is invalid
+func (v *sigV4Verifier) lastSignedRequest(t *testing.T, err error) signedRequest {
+	t.Helper()
+	received := v.lastRequest(t)
+	if err != nil || received.signatureErr != "" {
+		t.Fatalf("awscurl failed: %v, the server responded with %q", err, received.signatureErr)
+	}
+	return received
+}
+
+func TestSigV4VerifierRejectsInvalidSignature(t *testing.T) {
+	server := newSigV4Verifier(t, aws.Credentials{AccessKeyID: testCredentials.AccessKeyID, SecretAccessKey: "another-secret"})
+	if _, err := runAwscurl(t, server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if reason := server.lastRequest(t).signatureErr; reason != "the signature doesn't match" {
+		t.Errorf("the server rejected the request because %q", reason)
+	}
+}
+
+func TestMultipleHeadersInSingleValue(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "-H", `X-First: 1\nX-Second: a:b\n\nX-Third;`, "-H", "X-Fourth: 4", server.URL)
+	received := server.lastSignedRequest(t, err)
+
+	for name, want := range map[string]string{"X-First": "1", "X-Second": "a:b", "X-Third": "", "X-Fourth": "4"} {
+		values, ok := received.Header[name]
+		if !ok || len(values) != 1 || values[0] != want {
+			t.Errorf("%s = %q, want %q", name, values, want)
+		}
+		if !strings.Contains(received.Header.Get("Authorization"), strings.ToLower(name)) {
+			t.Errorf("%s isn't signed: %s", name, received.Header.Get("Authorization"))
+		}
+	}
+}
+
+func TestMultipleHeadersInvalid(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "-H", `X-First: 1\nX-Second`, server.URL)
+	if err == nil || !strings.Contains(err.Error(), "Invalid header: X-Second") {
+		t.Errorf("awscurl error = %v, want the invalid header", err)
+	}
+	if len(server.requests) != 0 {
+		t.Error("the request is sent with the invalid header")
+	}
+}
+
+func TestServiceContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "API Gateway", args: []string{"--service", "execute-api", "-d", "{}"}, want: "application/json"},
+		{name: "DynamoDB", args: []string{"--service", "dynamodb", "-d", "{}"}, want: "application/x-amz-json-1.0"},
+		{name: "CloudWatch Logs", args: []string{"--service", "logs", "-d", "{}"}, want: "application/x-amz-json-1.1"},
+		{name: "SQS", args: []string{"--service", "sqs", "-d", "Action=ListQueues"}, want: "application/x-www-form-urlencoded"},
+		{name: "S3 has no default", args: []string{"--service", "s3", "-X", "PUT", "-d", "content"}, want: ""},
+		{name: "unknown service has no default", args: []string{"--service", "newservice", "-d", "{}"}, want: ""},
+		{name: "no payload", args: []string{"--service", "dynamodb"}, want: ""},
+		{name: "-H wins", args: []string{"--service", "dynamodb", "-H", "Content-Type: text/plain", "-d", "{}"}, want: "text/plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			_, err := runAwscurl(t, append(tt.args, server.URL)...)
+			received := server.lastSignedRequest(t, err)
+			if got := received.Header.Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressInput(t *testing.T) {
+	payload := `{"items": ["a", "b", "c"]}`
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(payload))
+	gz.Close()
+	dir := t.TempDir()
+	gzFile := filepath.Join(dir, "payload.json.gz")
+	if err := ioutil.WriteFile(gzFile, compressed.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	plainFile := filepath.Join(dir, "payload.json")
+	if err := ioutil.WriteFile(plainFile, []byte(payload), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "-d", args: []string{"--decompress-input", "-d", "@" + gzFile}, want: payload},
+		{name: "without the flag", args: []string{"-d", "@" + gzFile}, want: compressed.String()},
+		{name: "not gzipped", args: []string{"--decompress-input", "-d", "@" + plainFile}, wantErr: "Unable to decompress " + plainFile},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			_, err := runAwscurl(t, append(tt.args, server.URL)...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("awscurl error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			// The payload is decompressed before it's hashed, so the signature covers the payload actually sent
+			received := server.lastSignedRequest(t, err)
+			if string(received.body) != tt.want {
+				t.Errorf("body = %q, want %q", received.body, tt.want)
+			}
+		})
+	}
+}
+
+func TestCustomMethods(t *testing.T) {
+	for _, method := range []string{"PURGE", "PROPFIND", "PATCH", "X-CUSTOM_1"} {
+		t.Run(method, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			_, err := runAwscurl(t, "-X", method, "-d", "<propfind/>", server.URL+"/resource")
+			received := server.lastSignedRequest(t, err)
+			if received.Method != method {
+				t.Errorf("method = %q, want %q", received.Method, method)
+			}
+			if string(received.body) != "<propfind/>" {
+				t.Errorf("body = %q, want the payload", received.body)
+			}
+		})
+	}
+}
+
+func TestInvalidMethod(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	for _, method := range []string{"", "GET /", "PUR(GE", "GÉT"} {
+		_, err := runAwscurl(t, "-X", method, server.URL)
+		if err == nil || !strings.Contains(err.Error(), "request method") {
+			t.Errorf("awscurl -X %q error = %v, want the invalid method", method, err)
+		}
+	}
+	if len(server.requests) != 0 {
+		t.Errorf("%d requests are sent with the invalid method", len(server.requests))
+	}
+}
+
+func TestNoAutoContentSHA256(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantHeader bool
+	}{
+		{name: "default", args: []string{"-d", "{}"}},
+		{name: "S3", args: []string{"--service", "s3", "-X", "PUT", "-d", "content"}, wantHeader: true},
+		{name: "S3 Object Lambda", args: []string{"--service", "s3-object-lambda"}, wantHeader: true},
+		{name: "unsigned payload", args: []string{"--service", "vpc-lattice-svcs", "-d", "{}"}, wantHeader: true},
+		{name: "no header for S3", args: []string{"--no-auto-content-sha256", "--service", "s3", "-X", "PUT", "-d", "content"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			_, err := runAwscurl(t, append(tt.args, server.URL)...)
+			received := server.lastSignedRequest(t, err)
+			_, sent := received.Header[contentSHA256Header]
+			signed := strings.Contains(received.Header.Get("Authorization"), "x-amz-content-sha256")
+			if sent != tt.wantHeader || signed != tt.wantHeader {
+				t.Errorf("%s is sent: %v, signed: %v, want %v", contentSHA256Header, sent, signed, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestRequestPayer(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "--request-payer", "--service", "s3", "-H", "X-Amz-Meta-Owner: test", server.URL+"/bucket/key")
+	received := server.lastSignedRequest(t, err)
+	if payer := received.Header.Get(requestPayerHeader); payer != "requester" {
+		t.Errorf("%s = %q, want %q", requestPayerHeader, payer, "requester")
+	}
+
+	// S3 requires all the x-amz-* headers to be signed
+	signedHeaders := received.Header.Get("Authorization")
+	signedHeaders = signedHeaders[strings.Index(signedHeaders, "SignedHeaders="):]
+	for _, name := range []string{"x-amz-request-payer", "x-amz-meta-owner", "x-amz-content-sha256", "x-amz-date"} {
+		if !strings.Contains(signedHeaders, name) {
+			t.Errorf("%s isn't signed: %s", name, signedHeaders)
+		}
+	}
+}
+
+func TestNormalizeMethod(t *testing.T) {
+	tests := []struct {
+		method  string
+		want    string
+		wantErr bool
+	}{
+		{method: "GET", want: "GET"},
+		{method: "get", want: "GET"},
+		{method: " post \t", want: "POST"},
+		{method: "Propfind", want: "PROPFIND"},
+		{method: "M-SEARCH", want: "M-SEARCH"},
+		{method: "", wantErr: true},
+		{method: "   ", wantErr: true},
+		{method: "GET POST", wantErr: true},
+		{method: "GET\n", want: "GET"},
+		{method: "GE\nT", wantErr: true},
+		{method: "GET:", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeMethod(tt.method)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("normalizeMethod(%q) = %q, %v, want %q, wantErr %v", tt.method, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalizedMethodSigned(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "-X", " delete ", server.URL+"/items/1")
+	received := server.lastSignedRequest(t, err)
+	if received.Method != http.MethodDelete {
+		t.Errorf("method = %q, want %q", received.Method, http.MethodDelete)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		header  string
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{header: "X-Target: a:b:c", name: "X-Target", value: "a:b:c"},
+		{header: "Date: Tue, 02 Jan 2024 15:04:05 GMT", name: "Date", value: "Tue, 02 Jan 2024 15:04:05 GMT"},
+		{header: "X-Url:https://example.com:8443/", name: "X-Url", value: "https://example.com:8443/"},
+		{header: "X-Foo: \t bar ", name: "X-Foo", value: "bar "},
+		{header: "X-Empty;", name: "X-Empty", value: ""},
+		{header: "X-Empty:", name: "X-Empty", value: ""},
+		{header: "X-Foo", wantErr: true},
+		{header: ":value", wantErr: true},
+	}
+	for _, tt := range tests {
+		name, value, err := parseHeader(tt.header)
+		if name != tt.name || value != tt.value || (err != nil) != tt.wantErr {
+			t.Errorf("parseHeader(%q) = %q, %q, %v, want %q, %q, wantErr %v", tt.header, name, value, err, tt.name, tt.value, tt.wantErr)
+		}
+	}
+}
+
+func TestHeaderValuesSigned(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "-H", "X-Target: a:b:c", "-H", "X-Empty;", server.URL)
+	received := server.lastSignedRequest(t, err)
+	if target := received.Header.Get("X-Target"); target != "a:b:c" {
+		t.Errorf("X-Target = %q, want %q", target, "a:b:c")
+	}
+	if values, ok := received.Header["X-Empty"]; !ok || len(values) != 1 || values[0] != "" {
+		t.Errorf("X-Empty = %q, want the empty value", values)
+	}
+	if !strings.Contains(received.Header.Get("Authorization"), "x-empty;") {
+		t.Errorf("X-Empty isn't signed: %s", received.Header.Get("Authorization"))
+	}
+}
+
+func TestStaticSessionToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		creds aws.Credentials
+		args  []string
+	}{
+		{
+			name:  "without the token",
+			creds: aws.Credentials{AccessKeyID: "AKIDSTATIC", SecretAccessKey: "static-secret"},
+			args:  []string{"--access-key", "AKIDSTATIC", "--secret-key", "static-secret"},
+		},
+		{
+			name:  "with the token",
+			creds: aws.Credentials{AccessKeyID: "ASIASTATIC", SecretAccessKey: "static-secret", SessionToken: "static-token"},
+			args:  []string{"--access-key", "ASIASTATIC", "--secret-key", "static-secret", "--session-token", "static-token"},
+		},
+		{
+			name:  "with the token and a payload",
+			creds: aws.Credentials{AccessKeyID: "ASIASTATIC", SecretAccessKey: "static-secret", SessionToken: "static-token"},
+			args:  []string{"--access-key", "ASIASTATIC", "--secret-key", "static-secret", "--session-token", "static-token", "-d", "{}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, tt.creds)
+			output, err := runAwscurl(t, append(tt.args, server.URL+"/items?a=1")...)
+			received := server.lastSignedRequest(t, err)
+			if output != "OK\n" {
+				t.Errorf("output = %q, want %q", output, "OK\n")
+			}
+
+			token := received.Header.Get("X-Amz-Security-Token")
+			if token != tt.creds.SessionToken {
+				t.Errorf("X-Amz-Security-Token = %q, want %q", token, tt.creds.SessionToken)
+			}
+			if signed := strings.Contains(received.Header.Get("Authorization"), "x-amz-security-token"); signed != (tt.creds.SessionToken != "") {
+				t.Errorf("X-Amz-Security-Token is signed: %v, Authorization: %s", signed, received.Header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestStaticSessionTokenEnvironmentIgnored(t *testing.T) {
+	// AWS_SESSION_TOKEN belongs to the credentials in the environment, not to the keys given with the flags
+	server := newSigV4Verifier(t, aws.Credentials{AccessKeyID: "AKIDSTATIC", SecretAccessKey: "static-secret"})
+	t.Setenv("AWS_SESSION_TOKEN", "environment-token")
+	_, err := runAwscurl(t, "--access-key", "AKIDSTATIC", "--secret-key", "static-secret", server.URL)
+	if token := server.lastSignedRequest(t, err).Header.Get("X-Amz-Security-Token"); token != "" {
+		t.Errorf("X-Amz-Security-Token = %q, want none", token)
+	}
+}
+
+func TestStaticSessionTokenMissing(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, err := runAwscurl(t, "--access-key", "ASIASTATIC", "--secret-key", "static-secret", server.URL)
+	if err == nil || !strings.HasPrefix(err.Error(), "Error: The access key ASIASTATIC is temporary") {
+		t.Errorf("awscurl error = %v, want the missing session token", err)
+	}
+}
+
+func TestStrictWithFail(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	server.handler = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+
+	for _, args := range [][]string{{"--strict"}, {"--strict", "-f"}, {"-f", "--strict"}} {
+		output, err := runAwscurl(t, append(args, server.URL)...)
+		var statusErr *statusError
+		if !errors.As(err, &statusErr) || statusErr.exitCode() != 4 {
+			t.Errorf("%v: error = %v, want the status error with the exit code 4", args, err)
+		}
+		if output != "" {
+			t.Errorf("%v: output = %q, want no body", args, output)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultNetrcFile is the .netrc file read with --netrc, relative to the home directory
+const defaultNetrcFile = ".netrc"
+
+// netrcLogin is the login and the password of a machine in the .netrc file
+type netrcLogin struct {
+	login    string
+	password string
+}
+
+// netrc is the parsed .netrc file
+type netrc struct {
+	machines map[string]netrcLogin
+	// fallback is the "default" entry, used for any other machine
+	fallback *netrcLogin
+}
+
+// readNetrc parses the .netrc file. The macros defined with "macdef" are skipped.
+func readNetrc(path string) (*netrc, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to read the netrc file: %s", err)
+	}
+
+	n := &netrc{machines: make(map[string]netrcLogin)}
+	var current *netrcLogin
+	var machine string
+	// The entry is stored once it's complete, the first one wins for the same machine
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if machine == "" {
+			if n.fallback == nil {
+				n.fallback = current
+			}
+		} else if _, ok := n.machines[machine]; !ok {
+			n.machines[machine] = *current
+		}
+		current = nil
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		for j := 0; j < len(fields); j++ {
+			value := ""
+			if j+1 < len(fields) {
+				value = fields[j+1]
+			}
+			switch fields[j] {
+			case "machine":
+				flush()
+				current, machine = &netrcLogin{}, strings.ToLower(value)
+				j++
+			case "default":
+				flush()
+				current, machine = &netrcLogin{}, ""
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				j++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				j++
+			case "account":
+				j++
+			case "macdef":
+				// The macro lasts until the empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				j = len(fields)
+			}
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// login returns the login for the host, falling back to the "default" entry
+func (n *netrc) login(host string) (netrcLogin, bool) {
+	if l, ok := n.machines[strings.ToLower(host)]; ok {
+		return l, true
+	}
+	if n.fallback != nil {
+		return *n.fallback, true
+	}
+	return netrcLogin{}, false
+}
+
+// netrcPath returns the .netrc file to read: the one given with --netrc-file, or ~/.netrc with --netrc
+func netrcPath(file string, useDefault bool) (string, error) {
+	if file != "" || !useDefault {
+		return file, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("Error: Unable to find the netrc file: %s", err)
+	}
+	return filepath.Join(home, defaultNetrcFile), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadNetrc(t *testing.T) {
+	content := `machine proxy.example.com login alice password secret1
+machine PROXY.example.com login shadowed password shadowed
+
+machine other.example.com
+	login bob
+	account ignored
+	password secret2
+
+macdef init
+machine macro.example.com login macro password macro
+
+machine nopassword.example.com login carol
+default login fallback password secret3
+default login shadowed password shadowed
+`
+	path := filepath.Join(t.TempDir(), ".netrc")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := readNetrc(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want netrcLogin
+		ok   bool
+	}{
+		// The first entry of the machine wins, the machine names are case-insensitive
+		{host: "proxy.example.com", want: netrcLogin{login: "alice", password: "secret1"}, ok: true},
+		{host: "Proxy.Example.COM", want: netrcLogin{login: "alice", password: "secret1"}, ok: true},
+		{host: "other.example.com", want: netrcLogin{login: "bob", password: "secret2"}, ok: true},
+		{host: "nopassword.example.com", want: netrcLogin{login: "carol"}, ok: true},
+		// The macro body is not parsed as entries
+		{host: "macro.example.com", want: netrcLogin{login: "fallback", password: "secret3"}, ok: true},
+		{host: "unknown.example.com", want: netrcLogin{login: "fallback", password: "secret3"}, ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := n.login(tt.host)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("login(%q) = %+v, %v, want %+v, %v", tt.host, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if _, ok := (&netrc{machines: map[string]netrcLogin{}}).login("proxy.example.com"); ok {
+		t.Error("login() found the machine in the empty netrc file")
+	}
+}
+
+func TestReadNetrcMissingFile(t *testing.T) {
+	_, err := readNetrc(filepath.Join(t.TempDir(), ".netrc"))
+	if err == nil || !strings.HasPrefix(err.Error(), "Error: Unable to read the netrc file: ") {
+		t.Errorf("readNetrc() error = %v", err)
+	}
+}
+
+func TestNetrcPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		file       string
+		useDefault bool
+		want       string
+	}{
+		{file: "/etc/netrc", want: "/etc/netrc"},
+		{file: "/etc/netrc", useDefault: true, want: "/etc/netrc"},
+		{useDefault: true, want: filepath.Join(home, defaultNetrcFile)},
+		{want: ""},
+	}
+	for _, tt := range tests {
+		got, err := netrcPath(tt.file, tt.useDefault)
+		if err != nil || got != tt.want {
+			t.Errorf("netrcPath(%q, %v) = %q, %v, want %q", tt.file, tt.useDefault, got, err, tt.want)
+		}
+	}
+}
+
+func TestProxyAuthentication(t *testing.T) {
+	var received *http.Request
+	// The proxy answers the requests itself, recording them
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer proxy.Close()
+
+	netrcFile := filepath.Join(t.TempDir(), ".netrc")
+	if err := ioutil.WriteFile(netrcFile, []byte("machine 127.0.0.1 login netrc password netrc-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+
+	tests := []struct {
+		name  string
+		proxy string
+		args  []string
+		want  string
+	}{
+		{name: "none", proxy: proxy.URL},
+		{name: "--proxy-user", proxy: proxy.URL, args: []string{"--proxy-user", "alice:se:cret"}, want: basic("alice", "se:cret")},
+		{name: "proxy url", proxy: "http://bob:url-secret@" + proxy.Listener.Addr().String(), want: basic("bob", "url-secret")},
+		{name: "--netrc-file", proxy: proxy.URL, args: []string{"--netrc-file", netrcFile}, want: basic("netrc", "netrc-secret")},
+		{name: "--proxy-user wins over --netrc-file", proxy: proxy.URL, args: []string{"--netrc-file", netrcFile, "--proxy-user", "alice:secret"}, want: basic("alice", "secret")},
+		{name: "proxy url wins over --netrc-file", proxy: "http://bob:url-secret@" + proxy.Listener.Addr().String(), args: []string{"--netrc-file", netrcFile}, want: basic("bob", "url-secret")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = nil
+			args := append([]string{"--proxy", tt.proxy}, tt.args...)
+			if _, err := runAwscurl(t, append(args, "--service", "execute-api", "http://api.example.test/items")...); err != nil {
+				t.Fatal(err)
+			}
+			if received == nil {
+				t.Fatal("the request is not sent through the proxy")
+			}
+			if got := received.Header.Get("Proxy-Authorization"); got != tt.want {
+				t.Errorf("Proxy-Authorization = %q, want %q", got, tt.want)
+			}
+			// The server is still authenticated with the signature
+			if !strings.HasPrefix(received.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+				t.Errorf("Authorization = %q, want the signature", received.Header.Get("Authorization"))
+			}
+		})
+	}
+
+	if _, err := runAwscurl(t, "--proxy", proxy.URL, "--proxy-user", "alice", "http://api.example.test/items"); err == nil || !strings.Contains(err.Error(), "Invalid --proxy-user value") {
+		t.Errorf("awscurl error = %v, want the invalid --proxy-user", err)
+	}
+}
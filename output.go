@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// outputOptions control how the response body is written, see writeBody
+type outputOptions struct {
+	autoDecompress bool
+	filter         string
+	verifyETag     bool
+	// prettyJSON re-indents the body, it's expected to be set only for JSON responses
+	prettyJSON bool
+	// appendFile appends the body to the existing output file instead of overwriting it, to resume a download
+	appendFile bool
+}
+
+// writeOutputFile streams the response body to the file at the given path and returns the number of the received bytes.
+// The existing file is truncated, unless noClobber is set, in which case an error is returned.
+// With verifyETag, the body is written to a temporary file, which replaces the output file only once the ETag matches.
+func writeOutputFile(path string, noClobber bool, body io.Reader, etag string, opts outputOptions) (int64, error) {
+	if opts.verifyETag && !opts.appendFile {
+		return writeVerifiedFile(path, noClobber, body, etag, opts)
+	}
+
+	fileFlags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if noClobber {
+		fileFlags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	if opts.appendFile {
+		fileFlags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(path, fileFlags, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return 0, fmt.Errorf("Error: The output file %s already exists, not overwriting it due to --no-clobber", path)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	received, err := writeBody(f, body, etag, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return received, err
+}
+
+// writeVerifiedFile writes the body to a temporary file next to the output one and renames it to the output file
+// if the ETag matches, so a corrupted download never replaces the file. It's removed otherwise.
+func writeVerifiedFile(path string, noClobber bool, body io.Reader, etag string, opts outputOptions) (int64, error) {
+	if _, err := os.Stat(path); noClobber && err == nil {
+		return 0, fmt.Errorf("Error: The output file %s already exists, not overwriting it due to --no-clobber", path)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	received, err := writeBody(f, body, etag, opts)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return received, err
+}
+
+// writeBody copies the response body to w, decompressing and filtering it if requested, and returns
+// the number of the received bytes. The ETag is verified against the received body, not the written output.
+func writeBody(w io.Writer, body io.Reader, etag string, opts outputOptions) (int64, error) {
+	hash := md5.New()
+	var received byteCounter
+	raw := io.TeeReader(body, io.MultiWriter(hash, &received))
+
+	r := raw
+	var err error
+	if opts.autoDecompress {
+		if r, err = gunzipIfGzipped(r); err != nil {
+			return int64(received), err
+		}
+	}
+
+	if opts.prettyJSON {
+		if r, err = indentJSON(r); err != nil {
+			return int64(received), err
+		}
+	}
+
+	if opts.filter != "" {
+		err = runOutputFilter(opts.filter, r, w)
+	} else {
+		_, err = io.Copy(w, r)
+	}
+	if err != nil {
+		return int64(received), err
+	}
+
+	// Read the rest of the body, if the filter or the decompressor didn't, so the whole of it is verified
+	if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+		return int64(received), err
+	}
+
+	if opts.verifyETag {
+		return int64(received), verifyETag(etag, hash.Sum(nil))
+	}
+	return int64(received), nil
+}
+
+// indentJSON reads the JSON document completely and returns the reader of its indented version.
+// The content which isn't a valid JSON is returned unchanged.
+func indentJSON(r io.Reader) (io.Reader, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, content, "", "  "); err != nil {
+		return bytes.NewReader(content), nil
+	}
+	return &indented, nil
+}
+
+// isJSONContentType reports whether the media type is JSON, including the AWS JSON protocols
+// (e.g. application/x-amz-json-1.0) and the structured syntax suffix (e.g. application/problem+json)
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "application/x-amz-json") || strings.HasSuffix(mediaType, "+json")
+}
+
+// byteCounter is an io.Writer counting the bytes written
+type byteCounter int64
+
+func (c *byteCounter) Write(b []byte) (int, error) {
+	*c += byteCounter(len(b))
+	return len(b), nil
+}
+
+// responseMetadata is written by --write-metadata next to the downloaded object
+type responseMetadata struct {
+	Status     string      `json:"status"`
+	StatusCode int         `json:"status_code"`
+	ETag       string      `json:"etag,omitempty"`
+	Headers    http.Header `json:"headers"`
+}
+
+// writeMetadataFile writes the response status and headers as JSON to the file at the given path
+func writeMetadataFile(path string, response *http.Response) error {
+	metadata := responseMetadata{
+		Status:     response.Status,
+		StatusCode: response.StatusCode,
+		ETag:       response.Header.Get("ETag"),
+		Headers:    response.Header,
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// verifyETag compares the MD5 sum of the downloaded content with the ETag of the response.
+// ETags of multipart S3 uploads (containing "-") are not MD5 sums of the content, so they are skipped with a warning.
+func verifyETag(etag string, sum []byte) error {
+	etag = strings.Trim(etag, `"`)
+	switch {
+	case etag == "":
+		fmt.Fprintln(diagnostics, "Warning: The response has no ETag, skipping the verification")
+		return nil
+	case strings.Contains(etag, "-"):
+		fmt.Fprintf(diagnostics, "Warning: ETag %s belongs to a multipart object, skipping the verification\n", etag)
+		return nil
+	}
+
+	if actual := hex.EncodeToString(sum); !strings.EqualFold(actual, etag) {
+		return fmt.Errorf("Error: The downloaded content doesn't match the ETag: expected MD5 %s, got %s", etag, actual)
+	}
+	return nil
+}
+
+// runOutputFilter pipes the input through the shell command to the output.
+// The stderr of the command is passed through, so its own error messages are visible.
+func runOutputFilter(command string, in io.Reader, out io.Writer) error {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("sh", "-c", command)
+	}
+	c.Stdin = in
+	c.Stdout = out
+	c.Stderr = os.Stderr
+
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("Error: The output filter %q failed: %s", command, err)
+	}
+	return nil
+}
+
+// gzipMagic are the first bytes of any gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzipIfGzipped returns the reader decompressing the body if it starts with the gzip magic bytes,
+// otherwise the body is read as is. It's needed for objects stored gzipped without the Content-Encoding header,
+// which the HTTP client would handle.
+func gunzipIfGzipped(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	if magic, _ := br.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to decompress the response body: %s", err)
+	}
+	return gz, nil
+}
+
+// errMaxFileSize is returned by maxSizeReader once the body exceeds the limit
+var errMaxFileSize = errors.New("Error: The response body exceeds --max-filesize")
+
+// maxSizeReader fails once more than max bytes are read, in case the server sends more than its Content-Length says
+type maxSizeReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if m.n > m.max {
+		return n, errMaxFileSize
+	}
+	return n, err
+}
+
+// jsonResponse is written by --json-output instead of the response body
+type jsonResponse struct {
+	StatusCode int `json:"status_code"`
+	// Headers has the values of the repeated headers joined with ", "
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// BodyEncoding is "text" for a printable UTF-8 body, or "base64" for a binary one
+	BodyEncoding string       `json:"body_encoding"`
+	Timing       timingReport `json:"timing"`
+}
+
+// writeJSONResponse writes the whole response as a single JSON object followed by a newline
+func writeJSONResponse(w io.Writer, response *http.Response, body []byte, t *requestTimings) error {
+	out := jsonResponse{
+		StatusCode:   response.StatusCode,
+		Headers:      make(map[string]string, len(response.Header)),
+		Body:         string(body),
+		BodyEncoding: "text",
+		Timing:       newTimingReport(t, response.StatusCode, int64(len(body))),
+	}
+	for name, values := range response.Header {
+		out.Headers[name] = strings.Join(values, ", ")
+	}
+	if !isPrintable(body) {
+		out.Body, out.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+
+	return json.NewEncoder(w).Encode(out)
+}
+
+// dumpResponseHeaders writes the status line and the response headers to the given file, or to stdout for "-"
+func dumpResponseHeaders(path string, response *http.Response) error {
+	if path == "-" {
+		printResponseHeaders(os.Stdout, response)
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Error: Unable to create the --dump-header file: %s", err)
+	}
+	printResponseHeaders(f, response)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Error: Unable to write the --dump-header file: %s", err)
+	}
+	return nil
+}
+
+// printResponseHeaders prints the status line and the response headers followed by a blank line, as curl -i does.
+// The headers are sorted by name, so the output is deterministic.
+func printResponseHeaders(w io.Writer, response *http.Response) {
+	fmt.Fprintf(w, "%s %s\n", response.Proto, response.Status)
+
+	var names []string
+	for name := range response.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range response.Header[name] {
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+// bodyExpected tells whether the response to the request could have a body at all
+func bodyExpected(method string, status int) bool {
+	return method != http.MethodHead && status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// emptyBodyError describes the response with the unexpectedly empty body, along with the request ID for AWS support
+func emptyBodyError(response *http.Response) error {
+	contentLength := response.Header.Get("Content-Length")
+	if contentLength == "" {
+		contentLength = "not set"
+	}
+	msg := fmt.Sprintf("Error: The response body is empty: %s, Content-Length: %s", response.Status, contentLength)
+	if id := requestID(response.Header); id != "" {
+		msg += fmt.Sprintf(", request ID: %s", id)
+	}
+	return errors.New(msg)
+}
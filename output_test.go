@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteOutputFileVerifyETag(t *testing.T) {
+	diagnostics = ioutil.Discard
+	defer func() { diagnostics = os.Stderr }()
+
+	body := "object content"
+	sum := md5.Sum([]byte(body))
+	validETag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	tests := []struct {
+		name      string
+		existing  string
+		noClobber bool
+		etag      string
+		want      string
+		wantErr   bool
+	}{
+		{name: "matching", etag: validETag, want: body},
+		{name: "matching replaces the file", existing: "old", etag: validETag, want: body},
+		{name: "mismatching", etag: `"0123456789abcdef0123456789abcdef"`, wantErr: true},
+		{name: "mismatching keeps the file", existing: "old", etag: `"0123456789abcdef0123456789abcdef"`, want: "old", wantErr: true},
+		{name: "multipart is not verified", etag: `"0123456789abcdef0123456789abcdef-2"`, want: body},
+		{name: "no clobber", existing: "old", noClobber: true, etag: validETag, want: "old", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "object.txt")
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			received, err := writeOutputFile(path, tt.noClobber, strings.NewReader(body), tt.etag, outputOptions{verifyETag: true})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeOutputFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && received != int64(len(body)) {
+				t.Errorf("writeOutputFile() = %d, want %d", received, len(body))
+			}
+
+			content, err := ioutil.ReadFile(path)
+			switch {
+			case tt.want == "" && !os.IsNotExist(err):
+				t.Errorf("the output file exists with %q, want none", content)
+			case tt.want != "" && string(content) != tt.want:
+				t.Errorf("the output file = %q, %v, want %q", content, err, tt.want)
+			}
+
+			// The temporary file is never left behind
+			if files, _ := ioutil.ReadDir(dir); len(files) > 1 {
+				t.Errorf("the directory contains %d files, want at most 1", len(files))
+			}
+		})
+	}
+}
+
+func TestOutputFilterNewline(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "unfiltered", want: "OK\n"},
+		{name: "unfiltered without newline", args: []string{"--no-newline"}, want: "OK"},
+		{name: "filtered", args: []string{"--output-filter", "tr A-Z a-z"}, want: "ok"},
+		{name: "filter output with newline", args: []string{"--output-filter", "sed s/OK/done/; echo"}, want: "done\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := runAwscurl(t, append(tt.args, server.URL)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output != tt.want {
+				t.Errorf("output = %q, want %q", output, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package awscurl sends HTTP requests signed with AWS Signature Version 4, the same way as the awscurl CLI does.
+//
+// A minimal example:
+//
+//	cfg, err := awscurl.LoadConfig(ctx, awscurl.Options{Profile: "dev", Region: "us-east-1"})
+//	if err != nil {
+//		return err
+//	}
+//	client := awscurl.NewClient(cfg, "execute-api")
+//	response, err := client.Do(awscurl.Request{
+//		Method: "POST",
+//		URL:    "https://<prefix>.execute-api.us-east-1.amazonaws.com/<resource>",
+//		Header: http.Header{"Content-Type": {"application/json"}},
+//		Body:   []byte(`{"key": "value"}`),
+//	})
+package awscurl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// ContentSHA256Header carries the payload hash, so the service can verify the payload wasn't modified
+const ContentSHA256Header = "X-Amz-Content-Sha256"
+
+// ErrNoCredentials is returned when no AWS credentials are found in any of the sources
+var ErrNoCredentials = errors.New("no AWS credentials found")
+
+// Options are the AWS settings to load the config with. The empty ones are taken from the environment
+// and the shared config files, the same as by the AWS CLI.
+type Options struct {
+	Profile      string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+}
+
+// LoadConfig loads the AWS config with the given options. The static credentials are used only if both the access key
+// and the secret key are given. Any extra load options are applied after them.
+func LoadConfig(ctx context.Context, opts Options, extra ...func(*config.LoadOptions) error) (aws.Config, error) {
+	var sources []func(*config.LoadOptions) error
+	if opts.Profile != "" {
+		sources = append(sources, config.WithSharedConfigProfile(opts.Profile))
+	}
+	if opts.AccessKey != "" && opts.SecretKey != "" {
+		sources = append(sources, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(opts.AccessKey, opts.SecretKey, opts.SessionToken)))
+	}
+	sources = append(sources, extra...)
+
+	cfg, err := config.LoadDefaultConfig(ctx, sources...)
+	if err != nil {
+		return cfg, err
+	}
+	if opts.Region != "" {
+		cfg.Region = opts.Region
+	}
+	return cfg, nil
+}
+
+// Request is the HTTP request to sign and send
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Client signs and sends requests to a single AWS service
+type Client struct {
+	Config aws.Config
+	// Service is the signing name of the service, e.g. "execute-api" or "s3"
+	Service string
+	// Region overrides the region of the config, if set
+	Region string
+	// UnsignedPayload signs the request without the payload hash, as S3 allows
+	UnsignedPayload bool
+	// NoContentSHA256 doesn't add the X-Amz-Content-Sha256 header, for the endpoints rejecting it.
+	// The payload hash is signed all the same.
+	NoContentSHA256 bool
+	// DateHeader, SigV4A and Time are the same as in Signer
+	DateHeader string
+	SigV4A     bool
+	Time       time.Time
+	// HTTPClient sends the requests, http.DefaultClient is used if it's nil
+	HTTPClient *http.Client
+}
+
+// NewClient returns the client for the given service, which uses the credentials and the region of the config
+func NewClient(cfg aws.Config, service string) *Client {
+	return &Client{Config: cfg, Service: service}
+}
+
+// NewRequest builds the *http.Request from the request and signs it
+func (c *Client) NewRequest(ctx context.Context, req Request) (*http.Request, error) {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Body) == 0 {
+		httpReq.Body, httpReq.GetBody = http.NoBody, nil
+	}
+	for name, values := range req.Header {
+		for _, value := range values {
+			httpReq.Header.Add(name, value)
+		}
+	}
+
+	if err := c.Sign(ctx, httpReq, req.Body); err != nil {
+		return nil, err
+	}
+	return httpReq, nil
+}
+
+// NewSigner returns the signer for the service and the region of the client with the given credentials
+func (c *Client) NewSigner(creds aws.Credentials) *Signer {
+	region := c.Region
+	if region == "" {
+		region = c.Config.Region
+	}
+	return &Signer{
+		Credentials: creds,
+		Service:     c.Service,
+		Region:      region,
+		DateHeader:  c.DateHeader,
+		SigV4A:      c.SigV4A,
+		Time:        c.Time,
+	}
+}
+
+// Sign signs the request with the given body, using the credentials of the config. The request body itself is not read.
+func (c *Client) Sign(ctx context.Context, req *http.Request, body []byte) error {
+	if c.Config.Credentials == nil {
+		return ErrNoCredentials
+	}
+	creds, err := c.Config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	if !creds.HasKeys() {
+		return ErrNoCredentials
+	}
+
+	signer := c.NewSigner(creds)
+	if signer.Region == "" {
+		return fmt.Errorf("the region of the %s service is not set", c.Service)
+	}
+
+	payloadHash := c.PayloadHash(body)
+	if !c.NoContentSHA256 {
+		req.Header.Set(ContentSHA256Header, payloadHash)
+	}
+
+	_, err = signer.Sign(req, payloadHash)
+	return err
+}
+
+// PayloadHash returns the payload hash of the body to sign the request with
+func (c *Client) PayloadHash(body []byte) string {
+	if c.UnsignedPayload {
+		return UnsignedPayload
+	}
+	return HashSHA256(body)
+}
+
+// Do signs and sends the request. As with http.Client, the caller must close the response body.
+func (c *Client) Do(req Request) (*http.Response, error) {
+	httpReq, err := c.NewRequest(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(httpReq)
+}
+
+// ParseHeader parses the header in the curl format: "Name: Value", or "Name;" for the empty value.
+// The spaces before the value are trimmed, while the trailing ones are kept.
+func ParseHeader(h string) (name, value string, ok bool) {
+	parts := strings.SplitN(h, ":", 2)
+	if len(parts) != 2 && strings.HasSuffix(strings.TrimSpace(h), ";") {
+		parts = []string{strings.TrimSuffix(strings.TrimSpace(h), ";"), ""}
+	}
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimLeft(parts[1], " \t"), true
+}
+
+// HashSHA256 returns the hex-encoded SHA256 hash of the content, as used for the payload hash
+func HashSHA256(content []byte) string {
+	h := sha256.Sum256(content)
+	return fmt.Sprintf("%x", h[:])
+}
@@ -0,0 +1,255 @@
+package awscurl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+var testCredentials = aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		name   string
+		value  string
+		ok     bool
+	}{
+		{header: "Content-Type: application/json", name: "Content-Type", value: "application/json", ok: true},
+		{header: "X-Foo:bar", name: "X-Foo", value: "bar", ok: true},
+		{header: "X-Target: a:b:c", name: "X-Target", value: "a:b:c", ok: true},
+		{header: "X-Foo:  \tbar  ", name: "X-Foo", value: "bar  ", ok: true},
+		{header: " X-Foo : bar", name: "X-Foo", value: "bar", ok: true},
+		{header: "X-Empty:", name: "X-Empty", value: "", ok: true},
+		{header: "X-Empty;", name: "X-Empty", value: "", ok: true},
+		{header: "X-Empty ; ", name: "X-Empty", value: "", ok: true},
+		{header: "X-Foo", ok: false},
+		{header: ": bar", ok: false},
+		{header: ";", ok: false},
+		{header: "", ok: false},
+	}
+	for _, tt := range tests {
+		name, value, ok := ParseHeader(tt.header)
+		if name != tt.name || value != tt.value || ok != tt.ok {
+			t.Errorf("ParseHeader(%q) = %q, %q, %v, want %q, %q, %v", tt.header, name, value, ok, tt.name, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestHashSHA256(t *testing.T) {
+	if got, want := HashSHA256(nil), "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; got != want {
+		t.Errorf("HashSHA256(nil) = %s, want %s", got, want)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENVIRONMENT")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "environment-secret")
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	tests := []struct {
+		name       string
+		opts       Options
+		wantKey    string
+		wantRegion string
+	}{
+		{name: "environment", wantKey: "AKIDENVIRONMENT", wantRegion: "eu-west-1"},
+		{name: "static keys", opts: Options{AccessKey: "AKIDSTATIC", SecretKey: "static-secret"}, wantKey: "AKIDSTATIC", wantRegion: "eu-west-1"},
+		{name: "access key only", opts: Options{AccessKey: "AKIDSTATIC"}, wantKey: "AKIDENVIRONMENT", wantRegion: "eu-west-1"},
+		{name: "region", opts: Options{Region: "us-west-2"}, wantKey: "AKIDENVIRONMENT", wantRegion: "us-west-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			creds, err := cfg.Credentials.Retrieve(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if creds.AccessKeyID != tt.wantKey {
+				t.Errorf("access key = %q, want %q", creds.AccessKeyID, tt.wantKey)
+			}
+			if cfg.Region != tt.wantRegion {
+				t.Errorf("region = %q, want %q", cfg.Region, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestClientNewRequest(t *testing.T) {
+	signingTime := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	cfg := aws.Config{Credentials: credentials.StaticCredentialsProvider{Value: testCredentials}, Region: "us-east-1"}
+
+	tests := []struct {
+		name        string
+		client      Client
+		req         Request
+		wantMethod  string
+		wantRegion  string
+		payloadHash string
+	}{
+		{
+			name:        "GET",
+			client:      Client{Config: cfg, Service: "execute-api", Time: signingTime},
+			req:         Request{URL: "https://example.execute-api.us-east-1.amazonaws.com/prod"},
+			wantMethod:  http.MethodGet,
+			wantRegion:  "us-east-1",
+			payloadHash: HashSHA256(nil),
+		},
+		{
+			name:   "POST with body",
+			client: Client{Config: cfg, Service: "execute-api", Time: signingTime},
+			req: Request{Method: http.MethodPost, URL: "https://example.execute-api.us-east-1.amazonaws.com/prod",
+				Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"key": "value"}`)},
+			wantMethod:  http.MethodPost,
+			wantRegion:  "us-east-1",
+			payloadHash: HashSHA256([]byte(`{"key": "value"}`)),
+		},
+		{
+			name:        "region override",
+			client:      Client{Config: cfg, Service: "execute-api", Region: "eu-west-1", Time: signingTime},
+			req:         Request{URL: "https://example.execute-api.eu-west-1.amazonaws.com/prod"},
+			wantMethod:  http.MethodGet,
+			wantRegion:  "eu-west-1",
+			payloadHash: HashSHA256(nil),
+		},
+		{
+			name:        "unsigned payload",
+			client:      Client{Config: cfg, Service: "s3", UnsignedPayload: true, Time: signingTime},
+			req:         Request{Method: http.MethodPut, URL: "https://bucket.s3.amazonaws.com/key", Body: []byte("content")},
+			wantMethod:  http.MethodPut,
+			wantRegion:  "us-east-1",
+			payloadHash: UnsignedPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := tt.client.NewRequest(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != tt.wantMethod {
+				t.Errorf("method = %s, want %s", req.Method, tt.wantMethod)
+			}
+			if hash := req.Header.Get(ContentSHA256Header); hash != tt.payloadHash {
+				t.Errorf("%s = %q, want %q", ContentSHA256Header, hash, tt.payloadHash)
+			}
+			if len(tt.req.Body) == 0 && req.Body != http.NoBody {
+				t.Error("the request without the body isn't sent with http.NoBody")
+			}
+
+			// The signature is the same as the one of the SDK signer at the fixed signing time
+			expected, _ := http.NewRequest(tt.wantMethod, tt.req.URL, nil)
+			expected.Header = tt.req.Header.Clone()
+			if expected.Header == nil {
+				expected.Header = http.Header{}
+			}
+			expected.Header.Set(ContentSHA256Header, tt.payloadHash)
+			expected.ContentLength = int64(len(tt.req.Body))
+			if err := v4.NewSigner().SignHTTP(context.Background(), testCredentials, expected, tt.payloadHash, tt.client.Service, tt.wantRegion, signingTime); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := req.Header.Get("Authorization"), expected.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestClientSignErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		client  Client
+		wantErr error
+		wantMsg string
+	}{
+		{name: "no credentials provider", client: Client{Service: "execute-api"}, wantErr: ErrNoCredentials},
+		{
+			name: "empty credentials",
+			client: Client{Config: aws.Config{Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{}, nil
+			}), Region: "us-east-1"}, Service: "execute-api"},
+			wantErr: ErrNoCredentials,
+		},
+		{
+			name:    "no region",
+			client:  Client{Config: aws.Config{Credentials: credentials.StaticCredentialsProvider{Value: testCredentials}}, Service: "execute-api"},
+			wantMsg: "the region of the execute-api service is not set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "https://example.execute-api.us-east-1.amazonaws.com/", nil)
+			err := tt.client.Sign(context.Background(), req, nil)
+			switch {
+			case err == nil:
+				t.Fatal("Sign() succeeded")
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("Sign() error = %v, want %v", err, tt.wantErr)
+			case tt.wantMsg != "" && err.Error() != tt.wantMsg:
+				t.Errorf("Sign() error = %v, want %q", err, tt.wantMsg)
+			}
+			if req.Header.Get("Authorization") != "" {
+				t.Error("the request is signed despite the error")
+			}
+		})
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	var received *http.Request
+	var receivedBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received, receivedBody = r, string(body)
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{Credentials: credentials.StaticCredentialsProvider{Value: testCredentials}, Region: "us-east-1"}
+	client := NewClient(cfg, "execute-api")
+	client.HTTPClient = server.Client()
+	client.DateHeader = "Date"
+
+	response, err := client.Do(Request{Method: http.MethodPost, URL: server.URL + "/items?b=2&a=1", Body: []byte("payload")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "OK" {
+		t.Errorf("response body = %q, want %q", body, "OK")
+	}
+
+	if receivedBody != "payload" {
+		t.Errorf("received body = %q, want %q", receivedBody, "payload")
+	}
+	if hash := received.Header.Get(ContentSHA256Header); hash != HashSHA256([]byte("payload")) {
+		t.Errorf("%s = %q, want the payload hash", ContentSHA256Header, hash)
+	}
+	authorization := received.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, SigningAlgorithm+" Credential=AKIDEXAMPLE/") || !strings.Contains(authorization, "/us-east-1/execute-api/aws4_request") {
+		t.Errorf("Authorization = %q, want the signature of execute-api in us-east-1", authorization)
+	}
+	if received.Header.Get("Date") == "" || received.Header.Get(AmzDateHeader) != "" {
+		t.Errorf("the signing time is sent in %q, want it in Date only", authorization)
+	}
+}
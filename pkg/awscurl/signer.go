@@ -0,0 +1,299 @@
+package awscurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Signer signs requests with SigV4, or with SigV4A if it's enabled.
+// It could sign the same request several times (e.g. on retries), every time replacing the previous signature.
+type Signer struct {
+	Credentials aws.Credentials
+	// Service is the signing name of the service, e.g. "execute-api" or "s3"
+	Service string
+	// Region is the signing region. With SigV4A, it's the comma-separated set of regions, e.g. "us-east-1,us-west-2" or "*"
+	Region string
+	// DateHeader carries the signing time, X-Amz-Date is used if it's empty.
+	// The Date header gets the HTTP date format, while any other header gets the ISO8601 basic format.
+	DateHeader string
+	// SigV4A signs the requests with SigV4A, then the signature is valid in the region set
+	SigV4A bool
+	// Time is the fixed signing time, the current time is used if it's zero
+	Time time.Time
+}
+
+// SigningTime returns the time to sign the request with
+func (s *Signer) SigningTime() time.Time {
+	if s.Time.IsZero() {
+		return time.Now()
+	}
+	return s.Time
+}
+
+// Sign signs the request with the given payload hash at the signing time and returns the signing time.
+// The request body itself is not read.
+func (s *Signer) Sign(req *http.Request, payloadHash string) (time.Time, error) {
+	signingTime := s.SigningTime()
+	if s.SigV4A {
+		return signingTime, signHTTPV4A(s.Credentials, req, payloadHash, s.Service, s.Region, signingTime)
+	}
+	if s.DateHeader != "" && !strings.EqualFold(s.DateHeader, AmzDateHeader) {
+		signHTTPWithDateHeader(s.Credentials, req, payloadHash, s.Service, s.Region, signingTime, s.DateHeader)
+		return signingTime, nil
+	}
+
+	err := v4.NewSigner().SignHTTP(req.Context(), s.Credentials, req, payloadHash, s.Service, s.Region, signingTime)
+	return signingTime, err
+}
+
+// Presign returns the URL of the request with the signature in the query, valid for the given duration,
+// along with the headers signed. The signed headers, except Host, must be sent along with the URL.
+// The request itself is not modified. The signing time is always passed in the X-Amz-Date query parameter,
+// whatever DateHeader is. Presigning with SigV4A is not supported.
+func (s *Signer) Presign(req *http.Request, payloadHash string, expires time.Duration) (string, http.Header, error) {
+	if s.SigV4A {
+		return "", nil, errors.New("presigning is not supported with SigV4A")
+	}
+
+	// S3 never knows the payload of a presigned request in advance
+	if s.Service == "s3" {
+		payloadHash = UnsignedPayload
+	}
+
+	presigned := req.Clone(req.Context())
+	presigned.Header.Del(ContentSHA256Header)
+	query := presigned.URL.Query()
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	presigned.URL.RawQuery = query.Encode()
+
+	return v4.NewSigner().PresignHTTP(req.Context(), s.Credentials, presigned, payloadHash, s.Service, s.Region, s.SigningTime())
+}
+
+// The code below mirrors the canonicalization done by the AWS SDK v4 signer (aws/signer/v4),
+// which doesn't allow to customize it or to inspect the intermediate results.
+
+const (
+	// SigningAlgorithm is the algorithm of SigV4 in the Authorization header and in the string to sign
+	SigningAlgorithm = "AWS4-HMAC-SHA256"
+	// AmzDateHeader carries the signing time by default
+	AmzDateHeader = "X-Amz-Date"
+	// AmzDateFormat is the ISO8601 basic format of the signing time
+	AmzDateFormat   = "20060102T150405Z"
+	shortDateFormat = "20060102"
+
+	// UnsignedPayload is used instead of the payload hash by the services which don't support the payload signing
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// ignoredSigningHeaders are never included to the signature, the same as in the AWS SDK signer
+var ignoredSigningHeaders = map[string]bool{
+	"Authorization":   true,
+	"User-Agent":      true,
+	"X-Amzn-Trace-Id": true,
+}
+
+// canonicalRequest contains the parts of the SigV4 canonical request
+type canonicalRequest struct {
+	Method        string
+	URI           string
+	Query         string
+	Headers       string
+	SignedHeaders string
+	PayloadHash   string
+}
+
+func (c canonicalRequest) String() string {
+	return strings.Join([]string{c.Method, c.URI, c.Query, c.Headers, c.SignedHeaders, c.PayloadHash}, "\n")
+}
+
+// buildCanonicalRequest builds the SigV4 canonical request for the given *http.Request.
+// Please note that it sorts the query parameters of the request URL in place, the same as the SDK signer does.
+func buildCanonicalRequest(req *http.Request, payloadHash string) canonicalRequest {
+	query := req.URL.Query()
+	for key := range query {
+		sort.Strings(query[key])
+	}
+	req.URL.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	host := signingHost(req)
+	signed := map[string][]string{"host": {host}}
+	if req.ContentLength > 0 {
+		signed["content-length"] = []string{strconv.FormatInt(req.ContentLength, 10)}
+	}
+	for k, v := range req.Header {
+		// Content-Length is taken from the request itself, the header is never sent as is
+		if ignoredSigningHeaders[http.CanonicalHeaderKey(k)] || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+		lowerKey := strings.ToLower(k)
+		signed[lowerKey] = append(signed[lowerKey], v...)
+	}
+
+	var names []string
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var headers strings.Builder
+	for _, k := range names {
+		values := make([]string, len(signed[k]))
+		for i, v := range signed[k] {
+			values[i] = canonicalHeaderValue(v)
+		}
+		headers.WriteString(k + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	return canonicalRequest{
+		Method:        req.Method,
+		URI:           escapePath(canonicalURI(req.URL)),
+		Query:         req.URL.RawQuery,
+		Headers:       headers.String(),
+		SignedHeaders: strings.Join(names, ";"),
+		PayloadHash:   payloadHash,
+	}
+}
+
+// canonicalHeaderValue trims the header value and collapses the runs of spaces inside it into a single one
+func canonicalHeaderValue(value string) string {
+	value = strings.Trim(value, " ")
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == ' ' && i > 0 && value[i-1] == ' ' {
+			continue
+		}
+		b.WriteByte(value[i])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// signingHost returns the host used for signing, without the default port for the URL scheme
+func signingHost(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	hostname, port := host, ""
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname, port = host[:i], host[i+1:]
+	}
+	scheme := strings.ToLower(req.URL.Scheme)
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		return hostname
+	}
+	return host
+}
+
+func canonicalURI(u *url.URL) string {
+	uri := u.EscapedPath()
+	if u.Opaque != "" {
+		uri = "/" + strings.Join(strings.Split(u.Opaque, "/")[3:], "/")
+	}
+	if uri == "" {
+		uri = "/"
+	}
+	return uri
+}
+
+// escapePath escapes every byte of the path except unreserved characters and "/"
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func credentialScope(t time.Time, region, service string) string {
+	return strings.Join([]string{t.UTC().Format(shortDateFormat), region, service, "aws4_request"}, "/")
+}
+
+func buildStringToSign(t time.Time, scope string, canonical canonicalRequest) string {
+	return strings.Join([]string{SigningAlgorithm, t.UTC().Format(AmzDateFormat), scope, HashSHA256([]byte(canonical.String()))}, "\n")
+}
+
+// DeriveSigningKey derives the SigV4 signing key from the secret key for the date of the signing time, the region and the service
+func DeriveSigningKey(secretKey string, t time.Time, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), t.UTC().Format(shortDateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signHTTPWithDateHeader signs the request with SigV4 like v4.Signer.SignHTTP does,
+// but passes the signing time in the given header instead of X-Amz-Date.
+// The `Date` header is set in the HTTP date format, while any other header gets the ISO8601 basic format.
+func signHTTPWithDateHeader(creds aws.Credentials, req *http.Request, payloadHash, service, region string, signingTime time.Time, dateHeader string) {
+	if http.CanonicalHeaderKey(dateHeader) == "Date" {
+		req.Header.Set(dateHeader, signingTime.UTC().Format(http.TimeFormat))
+	} else {
+		req.Header.Set(dateHeader, signingTime.UTC().Format(AmzDateFormat))
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	req.Header.Del("Authorization")
+
+	canonical := buildCanonicalRequest(req, payloadHash)
+	scope := credentialScope(signingTime, region, service)
+	stringToSign := buildStringToSign(signingTime, scope, canonical)
+	signature := hex.EncodeToString(hmacSHA256(DeriveSigningKey(creds.SecretAccessKey, signingTime, region, service), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		SigningAlgorithm, creds.AccessKeyID, scope, canonical.SignedHeaders, signature))
+}
+
+// WriteSigningDetails writes the canonical request, the string to sign and the signing key derivation steps
+// for the already signed request. The secret key and the derived key bytes are never written.
+func WriteSigningDetails(w io.Writer, creds aws.Credentials, req *http.Request, payloadHash, service, region string, signingTime time.Time) error {
+	canonical := buildCanonicalRequest(req, payloadHash)
+	scope := credentialScope(signingTime, region, service)
+	stringToSign := buildStringToSign(signingTime, scope, canonical)
+	signature := hex.EncodeToString(hmacSHA256(DeriveSigningKey(creds.SecretAccessKey, signingTime, region, service), stringToSign))
+
+	_, err := fmt.Fprintf(w, `# Canonical request
+%s
+
+# String to sign
+%s
+
+# Credential scope
+%s
+
+# Signing key derivation
+kDate    = HMAC-SHA256("AWS4" + <secret key>, %q)
+kRegion  = HMAC-SHA256(kDate, %q)
+kService = HMAC-SHA256(kRegion, %q)
+kSigning = HMAC-SHA256(kService, "aws4_request")
+
+# Signature
+HMAC-SHA256(kSigning, <string to sign>) = %s
+`, canonical, stringToSign, scope, signingTime.UTC().Format(shortDateFormat), region, service, signature)
+
+	return err
+}
@@ -0,0 +1,284 @@
+package awscurl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+func TestCanonicalHeaderValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"a", "a"},
+		{"  a   b  ", "a b"},
+		{"a  b  c", "a b c"},
+		{"\ta b\t", "a b"},
+		{"", ""},
+		{"   ", ""},
+	}
+	for _, tt := range tests {
+		if got := canonicalHeaderValue(tt.value); got != tt.want {
+			t.Errorf("canonicalHeaderValue(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestSignHTTPWithDateHeaderMatchesSDK checks the canonicalization mirrored from the SDK gives the same
+// signature as v4.Signer, when the signing time is passed in X-Amz-Date
+func TestSignHTTPWithDateHeaderMatchesSDK(t *testing.T) {
+	signingTime := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	payloadHash := HashSHA256([]byte("{}"))
+
+	tests := []struct {
+		name    string
+		url     string
+		headers [][2]string
+		creds   aws.Credentials
+		length  int64
+	}{
+		{
+			name: "plain",
+			url:  "https://example.execute-api.us-east-1.amazonaws.com/prod/items",
+		},
+		{
+			name:    "header whitespace",
+			url:     "https://example.execute-api.us-east-1.amazonaws.com/",
+			headers: [][2]string{{"X-Foo", "  a   b  "}, {"X-Bar", "\tc d\t"}},
+		},
+		{
+			name:    "repeated headers",
+			url:     "https://example.execute-api.us-east-1.amazonaws.com/",
+			headers: [][2]string{{"X-Foo", "b"}, {"X-Foo", " a  "}, {"x-foo", "c"}},
+		},
+		{
+			name: "query parameters",
+			url:  "https://example.execute-api.us-east-1.amazonaws.com/search?q=b&q=a&z=1&a=x%20y",
+		},
+		{
+			name:    "escaped path",
+			url:     "https://example.execute-api.us-east-1.amazonaws.com/a%20b/c",
+			headers: [][2]string{{"Content-Type", "application/json"}},
+		},
+		{
+			name:  "session token",
+			url:   "https://example.execute-api.us-east-1.amazonaws.com/",
+			creds: aws.Credentials{SessionToken: "token"},
+		},
+		{
+			name:    "content length",
+			url:     "https://example.execute-api.us-east-1.amazonaws.com/",
+			headers: [][2]string{{"Content-Length", "2"}},
+			length:  2,
+		},
+		{
+			name:    "ignored headers",
+			url:     "https://example.execute-api.us-east-1.amazonaws.com:443/",
+			headers: [][2]string{{"User-Agent", "awscurl"}, {"X-Amzn-Trace-Id", "Root=1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := tt.creds
+			creds.AccessKeyID, creds.SecretAccessKey = "AKIDEXAMPLE", "secret"
+
+			newRequest := func() *http.Request {
+				req, err := http.NewRequest(http.MethodPost, tt.url, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, h := range tt.headers {
+					req.Header.Add(h[0], h[1])
+				}
+				req.ContentLength = tt.length
+				return req
+			}
+
+			expected := newRequest()
+			if err := v4.NewSigner().SignHTTP(context.Background(), creds, expected, payloadHash, "execute-api", "us-east-1", signingTime); err != nil {
+				t.Fatal(err)
+			}
+			actual := newRequest()
+			signHTTPWithDateHeader(creds, actual, payloadHash, "execute-api", "us-east-1", signingTime, AmzDateHeader)
+
+			if got, want := actual.Header.Get("Authorization"), expected.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+
+			// --dump-signing prints the signature of the request, which is already signed by the SDK
+			var details strings.Builder
+			if err := WriteSigningDetails(&details, creds, expected, payloadHash, "execute-api", "us-east-1", signingTime); err != nil {
+				t.Fatal(err)
+			}
+			signature := expected.Header.Get("Authorization")[strings.Index(expected.Header.Get("Authorization"), "Signature=")+len("Signature="):]
+			if !strings.Contains(details.String(), "= "+signature+"\n") {
+				t.Errorf("signing details don't contain the signature %s:\n%s", signature, details.String())
+			}
+		})
+	}
+}
+
+// signatureOf returns the signature from the Authorization header of the signed request
+func signatureOf(t *testing.T, req *http.Request) string {
+	t.Helper()
+	authorization := req.Header.Get("Authorization")
+	i := strings.Index(authorization, "Signature=")
+	if i < 0 {
+		t.Fatalf("the request isn't signed: %q", authorization)
+	}
+	return authorization[i+len("Signature="):]
+}
+
+func TestSignerSign(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	signingTime := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		dateHeader string
+		wantHeader string
+		wantDate   string
+	}{
+		{name: "default", wantHeader: "X-Amz-Date", wantDate: "20240102T150405Z"},
+		{name: "X-Amz-Date", dateHeader: "x-amz-date", wantHeader: "X-Amz-Date", wantDate: "20240102T150405Z"},
+		{name: "Date", dateHeader: "Date", wantHeader: "Date", wantDate: "Tue, 02 Jan 2024 15:04:05 GMT"},
+		{name: "custom header", dateHeader: "X-Signing-Date", wantHeader: "X-Signing-Date", wantDate: "20240102T150405Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &Signer{Credentials: creds, Service: "execute-api", Region: "us-east-1", DateHeader: tt.dateHeader, Time: signingTime}
+			req, _ := http.NewRequest(http.MethodGet, "https://example.execute-api.us-east-1.amazonaws.com/", nil)
+			got, err := signer.Sign(req, HashSHA256(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(signingTime) {
+				t.Errorf("Sign() = %s, want the fixed time %s", got, signingTime)
+			}
+			if date := req.Header.Get(tt.wantHeader); date != tt.wantDate {
+				t.Errorf("%s = %q, want %q", tt.wantHeader, date, tt.wantDate)
+			}
+			if tt.wantHeader != "X-Amz-Date" && req.Header.Get("X-Amz-Date") != "" {
+				t.Errorf("X-Amz-Date is set along with %s", tt.wantHeader)
+			}
+			if !strings.Contains(req.Header.Get("Authorization"), strings.ToLower(tt.wantHeader)) {
+				t.Errorf("%s isn't signed: %s", tt.wantHeader, req.Header.Get("Authorization"))
+			}
+
+			// Signing the same request again replaces the previous signature
+			first := signatureOf(t, req)
+			if _, err := signer.Sign(req, HashSHA256(nil)); err != nil {
+				t.Fatal(err)
+			}
+			if len(req.Header.Values("Authorization")) != 1 || signatureOf(t, req) != first {
+				t.Errorf("the request signed again has Authorization %q, want the same signature %s", req.Header.Values("Authorization"), first)
+			}
+		})
+	}
+}
+
+func TestSignerSignCurrentTime(t *testing.T) {
+	signer := &Signer{Credentials: aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, Service: "s3", Region: "us-east-1"}
+	req, _ := http.NewRequest(http.MethodGet, "https://bucket.s3.amazonaws.com/key", nil)
+	before := time.Now()
+	signingTime, err := signer.Sign(req, UnsignedPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signingTime.Before(before) || signingTime.After(time.Now()) {
+		t.Errorf("Sign() = %s, want the current time", signingTime)
+	}
+}
+
+func TestSignerSignV4A(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	signingTime := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	signer := &Signer{Credentials: creds, Service: "s3", Region: "us-east-1,us-west-2", SigV4A: true, Time: signingTime}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://mrap.accesspoint.s3-global.amazonaws.com/key", nil)
+	if _, err := signer.Sign(req, UnsignedPayload); err != nil {
+		t.Fatal(err)
+	}
+	if regions := req.Header.Get(RegionSetHeader); regions != "us-east-1,us-west-2" {
+		t.Errorf("%s = %q, want the region set", RegionSetHeader, regions)
+	}
+	if token := req.Header.Get("X-Amz-Security-Token"); token != "token" {
+		t.Errorf("X-Amz-Security-Token = %q, want %q", token, "token")
+	}
+	wantPrefix := sigV4AAlgorithm + " Credential=AKIDEXAMPLE/20240102/s3/aws4_request, "
+	if authorization := req.Header.Get("Authorization"); !strings.HasPrefix(authorization, wantPrefix) {
+		t.Errorf("Authorization = %q, want the prefix %q", authorization, wantPrefix)
+	}
+
+	// The signature is verified with the public key derived from the same credentials, as AWS does
+	signature, err := hex.DecodeString(signatureOf(t, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := buildCanonicalRequest(req, UnsignedPayload)
+	stringToSign := strings.Join([]string{sigV4AAlgorithm, "20240102T150405Z", "20240102/s3/aws4_request", HashSHA256([]byte(canonical.String()))}, "\n")
+	digest := sha256.Sum256([]byte(stringToSign))
+	key, err := deriveSigV4AKey(creds.AccessKeyID, creds.SecretAccessKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("the SigV4A signature isn't valid")
+	}
+}
+
+func TestSignerPresign(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	signer := &Signer{Credentials: creds, Service: "s3", Region: "us-east-1", Time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://bucket.s3.amazonaws.com/key", nil)
+	req.Header.Set("X-Foo", "bar")
+	req.Header.Set(ContentSHA256Header, HashSHA256(nil))
+	presigned, signedHeaders, err := signer.Presign(req, HashSHA256(nil), 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	for name, want := range map[string]string{
+		"X-Amz-Algorithm":      SigningAlgorithm,
+		"X-Amz-Credential":     "AKIDEXAMPLE/20240102/us-east-1/s3/aws4_request",
+		"X-Amz-Date":           "20240102T150405Z",
+		"X-Amz-Expires":        "900",
+		"X-Amz-Security-Token": "token",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if query.Get("X-Amz-Signature") == "" {
+		t.Error("the URL has no signature")
+	}
+	if _, ok := signedHeaders["X-Foo"]; !ok {
+		t.Errorf("the signed headers %v don't contain X-Foo", signedHeaders)
+	}
+	if req.URL.RawQuery != "" || req.Header.Get("Authorization") != "" {
+		t.Error("the request is modified by Presign")
+	}
+
+	signer.SigV4A = true
+	if _, _, err := signer.Presign(req, HashSHA256(nil), time.Minute); err == nil {
+		t.Error("Presign() with SigV4A succeeded")
+	}
+}
@@ -0,0 +1,92 @@
+package awscurl
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// SigV4A is the asymmetric variant of SigV4, the signature is valid in a set of regions rather than a single one.
+// It's required e.g. by S3 Multi-Region Access Points. The AWS SDK implements it in an internal package only,
+// so it's implemented here on top of the same canonical request as SigV4.
+
+const (
+	sigV4AAlgorithm = "AWS4-ECDSA-P256-SHA256"
+	// RegionSetHeader carries the set of regions the SigV4A signature is valid in
+	RegionSetHeader = "X-Amz-Region-Set"
+)
+
+// deriveSigV4AKey derives the ECDSA P-256 key pair from the access key pair (FIPS 186-4 Appendix B.4.2).
+// The candidate is produced by the HMAC-SHA256 KDF in the counter mode (NIST SP 800-108), and the counter
+// in the KDF context is increased until the candidate is less than N-2.
+func deriveSigV4AKey(accessKeyID, secretKey string) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	nMinusTwo := new(big.Int).Sub(curve.Params().N, big.NewInt(2))
+
+	for counter := 1; counter <= 0xFF; counter++ {
+		// i || label || 0x00 || context || length, where the context is the access key ID followed by the counter
+		var input bytes.Buffer
+		binary.Write(&input, binary.BigEndian, uint32(1))
+		input.WriteString(sigV4AAlgorithm)
+		input.WriteByte(0)
+		input.WriteString(accessKeyID)
+		input.WriteByte(byte(counter))
+		binary.Write(&input, binary.BigEndian, uint32(curve.Params().BitSize))
+
+		mac := hmac.New(sha256.New, []byte("AWS4A"+secretKey))
+		mac.Write(input.Bytes())
+		candidate := new(big.Int).SetBytes(mac.Sum(nil))
+		if candidate.Cmp(nMinusTwo) >= 0 {
+			continue
+		}
+
+		key := &ecdsa.PrivateKey{D: candidate.Add(candidate, big.NewInt(1))}
+		key.PublicKey.Curve = curve
+		key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(key.D.Bytes())
+		return key, nil
+	}
+	return nil, errors.New("unable to derive the SigV4A key from the credentials")
+}
+
+// signHTTPV4A signs the request with SigV4A for the given comma-separated set of regions, e.g. "us-east-1,us-west-2" or "*"
+func signHTTPV4A(creds aws.Credentials, req *http.Request, payloadHash, service, regionSet string, signingTime time.Time) error {
+	key, err := deriveSigV4AKey(creds.AccessKeyID, creds.SecretAccessKey)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(AmzDateHeader, signingTime.UTC().Format(AmzDateFormat))
+	req.Header.Set(RegionSetHeader, regionSet)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	req.Header.Del("Authorization")
+
+	// Unlike SigV4, the credential scope has no region
+	canonical := buildCanonicalRequest(req, payloadHash)
+	scope := strings.Join([]string{signingTime.UTC().Format(shortDateFormat), service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4AAlgorithm, signingTime.UTC().Format(AmzDateFormat), scope, HashSHA256([]byte(canonical.String()))}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4AAlgorithm, creds.AccessKeyID, scope, canonical.SignedHeaders, hex.EncodeToString(signature)))
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const progressBarWidth = 50
+
+// progressBar renders a single-line download progress, updating it in place.
+// It's an io.Writer counting the bytes written, supposed to be used with io.TeeReader.
+type progressBar struct {
+	w       io.Writer
+	total   int64
+	written int64
+	// shown is the last rendered value in tenths of percent, so the line is redrawn only when it changes
+	shown int64
+}
+
+func newProgressBar(w io.Writer, total int64) *progressBar {
+	return &progressBar{w: w, total: total, shown: -1}
+}
+
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.written += int64(len(b))
+	p.render()
+	return len(b), nil
+}
+
+func (p *progressBar) render() {
+	permille := p.written * 1000 / p.total
+	if permille > 1000 {
+		permille = 1000
+	}
+	if permille == p.shown {
+		return
+	}
+	p.shown = permille
+
+	filled := int(permille * progressBarWidth / 1000)
+	fmt.Fprintf(p.w, "\r%s%s %5.1f%%", strings.Repeat("#", filled), strings.Repeat(" ", progressBarWidth-filled), float64(permille)/10)
+}
+
+// finish ends the progress line, so the following output starts on a new one
+func (p *progressBar) finish() {
+	fmt.Fprintln(p.w)
+}
+
+// isTerminal reports whether the file is a terminal, rather than redirected to a file or a pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
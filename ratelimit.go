@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the average transfer rate in one direction, shared by all the connections.
+// The idle time isn't accumulated for long, so a transfer after a pause doesn't burst over the limit.
+type rateLimiter struct {
+	mu sync.Mutex
+	// rate is in bytes per second
+	rate  int64
+	start time.Time
+	total int64
+}
+
+// chunkSize returns the largest chunk to transfer at once, so the transfer is smooth rather than bursty
+func (l *rateLimiter) chunkSize(n int) int {
+	max := int(l.rate / 10)
+	if max < 1 {
+		max = 1
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// wait accounts the transferred bytes and sleeps until the average rate is within the limit
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.start.IsZero() || now.Sub(l.due()) > time.Second {
+		l.start, l.total = now, 0
+	}
+	l.total += int64(n)
+	delay := time.Until(l.due())
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// due returns the time when the bytes transferred so far are allowed by the rate
+func (l *rateLimiter) due() time.Time {
+	return l.start.Add(time.Duration(float64(l.total) / float64(l.rate) * float64(time.Second)))
+}
+
+// rateLimitedConn limits the rates of reading from and writing to the connection
+type rateLimitedConn struct {
+	net.Conn
+	read, write *rateLimiter
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:c.read.chunkSize(len(p))])
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.Conn.Write(p[written : written+c.write.chunkSize(len(p)-written)])
+		written += n
+		c.write.wait(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// limitRate wraps the dialer, so both the upload and the download of every connection are limited to the rate in bytes per second
+func limitRate(dial dialContextFunc, rate int64) dialContextFunc {
+	read, write := &rateLimiter{rate: rate}, &rateLimiter{rate: rate}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &rateLimitedConn{Conn: conn, read: read, write: write}, nil
+	}
+}
+
+// parseRate parses the --limit-rate value in bytes per second, e.g. "100K"
+func parseRate(value string) (int64, error) {
+	n, ok := parseByteSize(value)
+	if !ok {
+		return 0, fmt.Errorf(`Error: Invalid --limit-rate value: %s. It should be a positive number of bytes per second with an optional K, M or G suffix, e.g. "100K"`, value)
+	}
+	return n, nil
+}
+
+// parseByteSize parses the positive number of bytes with the optional K, M or G suffix (powers of 1024), e.g. "10M"
+func parseByteSize(value string) (int64, bool) {
+	multiplier := int64(1)
+	number := strings.TrimSpace(value)
+	if number != "" {
+		switch strings.ToUpper(number[len(number)-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/legal90/awscurl/pkg/awscurl"
+)
+
+// bucketRegionHeader is returned by S3 to tell the region of the bucket, e.g. in the 301 and 307 redirects
+const bucketRegionHeader = "X-Amz-Bucket-Region"
+
+// redirectPolicy returns the CheckRedirect function of the HTTP client. Unless follow is set, redirects are not followed.
+// Otherwise, the redirected request is signed again, since the original signature covers the original host and path.
+// The 301, 302 and 303 redirects are followed with GET and without the body, unless their statuses are in keepMethod.
+// The cookies, if any, are updated with the ones set by the redirect response and sent to the new location.
+func redirectPolicy(follow bool, maxRedirs int, keepMethod map[int]bool, cookies *cookieJar, signer *awscurl.Signer, payloadHash string) func(*http.Request, []*http.Request) error {
+	if !follow {
+		return func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirs >= 0 && len(via) > maxRedirs {
+			return fmt.Errorf("stopped after %d redirects", maxRedirs)
+		}
+
+		// Go drops the body once any redirect has dropped it, so it's restored for the later 307 and 308 as well
+		prev := via[len(via)-1]
+		status := req.Response.StatusCode
+		keep := keepMethod[status] || status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect
+		if keep && prev.Method != http.MethodGet && prev.Method != http.MethodHead {
+			if err := restoreRequestBody(req, prev); err != nil {
+				return err
+			}
+		}
+
+		// The Cookie header given with -H is dropped by Go for another host, the rest is picked for the new location
+		if cookies != nil {
+			cookies.setCookies(req.Response.Request.URL, req.Response.Cookies())
+			cookies.setHeader(req, len(req.Header["Cookie"]) > 0)
+		}
+
+		// The redirect could drop the payload, e.g. 303 is always followed with GET
+		hash := payloadHash
+		if (req.Body == nil || req.Body == http.NoBody) && payloadHash != unsignedPayload {
+			hash = hashSHA256(nil)
+		}
+		if req.Header.Get(contentSHA256Header) != "" {
+			req.Header.Set(contentSHA256Header, hash)
+		}
+
+		// S3 redirects to the bucket region, which must be used in the signature as well
+		s := *signer
+		if region := req.Response.Header.Get(bucketRegionHeader); region != "" {
+			s.Region = region
+		}
+		_, err := s.Sign(req, hash)
+		return err
+	}
+}
+
+// restoreRequestBody sends the redirected request with the method, the body and the body headers of the previous one
+func restoreRequestBody(req, prev *http.Request) error {
+	req.Method = prev.Method
+	if prev.GetBody != nil && (req.Body == nil || req.Body == http.NoBody) {
+		body, err := prev.GetBody()
+		if err != nil {
+			return err
+		}
+		req.Body, req.GetBody, req.ContentLength = body, prev.GetBody, prev.ContentLength
+	}
+	for _, name := range []string{"Content-Type", "Content-Encoding", "Content-Language", "Content-Location"} {
+		if values, ok := prev.Header[name]; ok && req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/legal90/awscurl/pkg/awscurl"
+)
+
+const (
+	retryInitialDelay = time.Second
+	retryMaxDelay     = 10 * time.Minute
+)
+
+// retryableStatuses are the response statuses of throttled requests and temporary service failures
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// jitterRand is seeded explicitly, so the jitter differs between the concurrently started processes
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// retrier sends the request, retrying it on transient failures.
+// Each attempt is signed again, because the signature includes the signing time.
+type retrier struct {
+	retries          int
+	retryConnRefused bool
+	// maxTime bounds the time since the first attempt, within which the retries could be started. Zero means no limit.
+	maxTime time.Duration
+}
+
+// newRetrier returns the retrier of --retry, --retry-connrefused and --retry-max-time
+func newRetrier() *retrier {
+	return &retrier{
+		retries:          flags.retry,
+		retryConnRefused: flags.retryConnRefused,
+		maxTime:          flags.retryMaxTime,
+	}
+}
+
+func (r *retrier) do(client *http.Client, req *http.Request, payloadHash string, signer *awscurl.Signer, timings *requestTimings) (*http.Response, error) {
+	ctx := req.Context()
+	started := time.Now()
+	delay := retryInitialDelay
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			req = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+			if _, err := signer.Sign(req, payloadHash); err != nil {
+				return nil, err
+			}
+		}
+
+		timings.start = time.Now()
+		response, err := client.Do(req)
+		if attempt >= r.retries || !r.isRetryable(ctx, response, err) {
+			return response, err
+		}
+
+		// The jitter spreads the retries of concurrent clients, so they don't hit the throttled service at once
+		wait := delay/2 + time.Duration(jitterRand.Int63n(int64(delay/2)+1))
+		reason := fmt.Sprint(err)
+		if err == nil {
+			reason = "The server responded with " + response.Status
+			if after, ok := retryAfter(response); ok {
+				wait = after
+			}
+		}
+		if r.maxTime > 0 && time.Since(started)+wait > r.maxTime {
+			return response, err
+		}
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		fmt.Fprintf(diagnostics, "Warning: %s. Will retry in %s, %d retries left\n", reason, wait.Round(time.Millisecond), r.retries-attempt)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// isRetryable reports whether the result of the attempt is a transient failure.
+// The errors caused by the request context (e.g. --max-time is exceeded) are never retried.
+func (r *retrier) isRetryable(ctx context.Context, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == nil {
+		return retryableStatuses[response.StatusCode]
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return r.retryConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the server in the Retry-After header, either in seconds or as an HTTP date
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var after time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		after = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		after = time.Until(date)
+	} else {
+		return 0, false
+	}
+
+	if after < 0 {
+		after = 0
+	} else if after > retryMaxDelay {
+		after = retryMaxDelay
+	}
+	return after, true
+}
+
+// sleepContext waits for the given duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
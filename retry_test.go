@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "no retries", retries: 0, failures: 1, wantAttempts: 1, wantErr: true},
+		{name: "succeeded on the retry", retries: 3, failures: 2, wantAttempts: 3},
+		{name: "retries exhausted", retries: 2, failures: 5, wantAttempts: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			attempts := 0
+			server.handler = func(w http.ResponseWriter, r *http.Request) {
+				if attempts++; attempts <= tt.failures {
+					// Retry-After keeps the test fast, overriding the backoff
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("OK"))
+			}
+
+			out, err := runAwscurl(t, "--retry", fmt.Sprint(tt.retries), "--fail", "--service", "execute-api", "-d", "payload", server.URL+"/items")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("awscurl error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && strings.TrimSpace(out) != "OK" {
+				t.Errorf("output = %q, want the response of the last attempt", out)
+			}
+			if len(server.requests) != tt.wantAttempts {
+				t.Fatalf("%d attempts, want %d", len(server.requests), tt.wantAttempts)
+			}
+			// Every attempt is signed again and sends the whole body
+			for i, received := range server.requests {
+				if received.signatureErr != "" || string(received.body) != "payload" {
+					t.Errorf("attempt %d: signature error %q, body %q", i+1, received.signatureErr, received.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryConnRefused(t *testing.T) {
+	// The port is free once the listener is closed, so the first attempt is refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := &sigV4Verifier{creds: testCredentials}
+	server.Server = httptest.NewUnstartedServer(http.HandlerFunc(server.serveHTTP))
+	defer server.Close()
+	// The server is started while awscurl waits for the retry
+	time.AfterFunc(100*time.Millisecond, func() {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Errorf("unable to listen on %s again: %s", addr, err)
+			return
+		}
+		server.Listener.Close()
+		server.Listener = listener
+		server.Start()
+	})
+
+	_, err = runAwscurl(t, "--retry", "2", "--retry-connrefused", "-d", "payload", "http://"+addr+"/items")
+	received := server.lastSignedRequest(t, err)
+	if string(received.body) != "payload" {
+		t.Errorf("body = %q, want the payload sent again", received.body)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("%d requests received, want 1", len(server.requests))
+	}
+}
+
+// timeoutError is the net.Error timing out, e.g. the dial or the response header timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	refused := &url.Error{Op: "Get", URL: "http://localhost", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}
+
+	tests := []struct {
+		name             string
+		ctx              context.Context
+		retryConnRefused bool
+		status           int
+		err              error
+		retry            bool
+	}{
+		{name: "200", status: http.StatusOK},
+		{name: "400", status: http.StatusBadRequest},
+		{name: "403", status: http.StatusForbidden},
+		{name: "429", status: http.StatusTooManyRequests, retry: true},
+		{name: "500", status: http.StatusInternalServerError, retry: true},
+		{name: "501", status: http.StatusNotImplemented},
+		{name: "502", status: http.StatusBadGateway, retry: true},
+		{name: "503", status: http.StatusServiceUnavailable, retry: true},
+		{name: "504", status: http.StatusGatewayTimeout, retry: true},
+		{name: "connection reset", err: &url.Error{Op: "Get", Err: syscall.ECONNRESET}, retry: true},
+		{name: "broken pipe", err: &url.Error{Op: "Get", Err: syscall.EPIPE}, retry: true},
+		{name: "unexpected eof", err: &url.Error{Op: "Get", Err: io.ErrUnexpectedEOF}, retry: true},
+		{name: "timeout", err: &url.Error{Op: "Get", Err: timeoutError{}}, retry: true},
+		{name: "unknown host", err: &url.Error{Op: "Get", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}},
+		{name: "other error", err: errors.New("invalid request")},
+		{name: "connection refused", err: refused},
+		{name: "connection refused with --retry-connrefused", retryConnRefused: true, err: refused, retry: true},
+		// --max-time is exceeded, or the request is interrupted
+		{name: "canceled", ctx: canceled, status: http.StatusServiceUnavailable},
+		{name: "canceled with error", ctx: canceled, err: &url.Error{Op: "Get", Err: context.Canceled}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &retrier{retries: 1, retryConnRefused: tt.retryConnRefused}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			var response *http.Response
+			if tt.err == nil {
+				response = &http.Response{StatusCode: tt.status}
+			}
+			if got := r.isRetryable(ctx, response, tt.err); got != tt.retry {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.retry)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: ""},
+		{value: "soon"},
+		{value: "0", want: 0, wantOK: true},
+		{value: "5", want: 5 * time.Second, wantOK: true},
+		{value: "-5", want: 0, wantOK: true},
+		{value: "86400", want: retryMaxDelay, wantOK: true},
+		{value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), want: 0, wantOK: true},
+		{value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), want: time.Minute, wantOK: true},
+	}
+	for _, tt := range tests {
+		response := &http.Response{Header: http.Header{}}
+		if tt.value != "" {
+			response.Header.Set("Retry-After", tt.value)
+		}
+		got, ok := retryAfter(response)
+		// The HTTP date is rounded down to seconds
+		if ok != tt.wantOK || got > tt.want || got < tt.want-time.Second {
+			t.Errorf("retryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryMaxTime(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	server.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+
+	// The retry would be started after the --retry-max-time, so the throttled response is returned at once
+	started := time.Now()
+	_, err := runAwscurl(t, "--retry", "3", "--retry-max-time", "30s", "--fail", "--service", "execute-api", server.URL)
+	if err == nil {
+		t.Error("awscurl succeeded with the throttled response")
+	}
+	if len(server.requests) != 1 || time.Since(started) > 10*time.Second {
+		t.Errorf("%d attempts in %s, want the only one without waiting", len(server.requests), time.Since(started))
+	}
+}
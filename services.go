@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// awsService describes an AWS service awscurl knows how to sign requests for
+type awsService struct {
+	// Name is the service name used for signing the request, i.e. the value for the `--service` flag
+	Name string
+	// Endpoints are the host patterns of the service endpoints, used to detect the service by the URL host.
+	// "*" matches any non-empty part of the host and "{region}" matches the region name.
+	Endpoints []string
+	// Description is a human-readable name of the service
+	Description string
+	// ContentType is the default Content-Type of the request payload, depends on the protocol the service API uses
+	ContentType string
+	// UnsignedPayload is set for the services which don't support the payload signing and require "UNSIGNED-PAYLOAD" instead
+	UnsignedPayload bool
+}
+
+// Content types of the AWS API protocols
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeAmzJSON = "application/x-amz-json-1.0"
+	contentTypeAmz11   = "application/x-amz-json-1.1"
+	contentTypeQuery   = "application/x-www-form-urlencoded"
+)
+
+// knownServices is the list of AWS services recognized by awscurl.
+// To support a new service, just add it here: the endpoint patterns are used both for the detection and the listing.
+var knownServices = []awsService{
+	{Name: "execute-api", Description: "Amazon API Gateway", Endpoints: []string{"*.execute-api.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "appsync", Description: "AWS AppSync", Endpoints: []string{"*.appsync-api.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "aps", Description: "Amazon Managed Service for Prometheus", Endpoints: []string{"aps-workspaces.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "athena", Description: "Amazon Athena", Endpoints: []string{"athena.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "bedrock", Description: "Amazon Bedrock", Endpoints: []string{"bedrock.{region}.amazonaws.com", "bedrock-runtime.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "cassandra", Description: "Amazon Keyspaces", Endpoints: []string{"cassandra.{region}.amazonaws.com"}},
+	{Name: "cloudformation", Description: "AWS CloudFormation", Endpoints: []string{"cloudformation.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "dynamodb", Description: "Amazon DynamoDB", Endpoints: []string{"dynamodb.{region}.amazonaws.com", "streams.dynamodb.{region}.amazonaws.com"}, ContentType: contentTypeAmzJSON},
+	{Name: "ec2", Description: "Amazon EC2", Endpoints: []string{"ec2.amazonaws.com", "ec2.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "ecr", Description: "Amazon Elastic Container Registry", Endpoints: []string{"api.ecr.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "es", Description: "Amazon OpenSearch Service", Endpoints: []string{"*.{region}.es.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "aoss", Description: "Amazon OpenSearch Serverless", Endpoints: []string{"*.{region}.aoss.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "events", Description: "Amazon EventBridge", Endpoints: []string{"events.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "firehose", Description: "Amazon Kinesis Data Firehose", Endpoints: []string{"firehose.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "glue", Description: "AWS Glue", Endpoints: []string{"glue.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "grafana", Description: "Amazon Managed Grafana", Endpoints: []string{"*.grafana-workspace.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "iam", Description: "AWS Identity and Access Management", Endpoints: []string{"iam.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "iotdata", Description: "AWS IoT Core data plane", Endpoints: []string{"*.iot.{region}.amazonaws.com"}, ContentType: contentTypeJSON},
+	{Name: "kinesis", Description: "Amazon Kinesis Data Streams", Endpoints: []string{"kinesis.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "kms", Description: "AWS Key Management Service", Endpoints: []string{"kms.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "lambda", Description: "AWS Lambda", Endpoints: []string{"lambda.{region}.amazonaws.com", "*.lambda-url.{region}.on.aws"}, ContentType: contentTypeJSON},
+	{Name: "logs", Description: "Amazon CloudWatch Logs", Endpoints: []string{"logs.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "monitoring", Description: "Amazon CloudWatch", Endpoints: []string{"monitoring.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "neptune-db", Description: "Amazon Neptune", Endpoints: []string{"*.{region}.neptune.amazonaws.com"}},
+	{Name: "s3", Description: "Amazon S3", Endpoints: []string{"s3.amazonaws.com", "*.s3.amazonaws.com", "s3.{region}.amazonaws.com", "*.s3.{region}.amazonaws.com",
+		"s3-{region}.amazonaws.com", "*.s3-{region}.amazonaws.com", "*.s3-accesspoint.{region}.amazonaws.com"}},
+	{Name: "sagemaker", Description: "Amazon SageMaker Runtime", Endpoints: []string{"runtime.sagemaker.{region}.amazonaws.com"}},
+	{Name: "secretsmanager", Description: "AWS Secrets Manager", Endpoints: []string{"secretsmanager.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "ses", Description: "Amazon Simple Email Service", Endpoints: []string{"email.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "sns", Description: "Amazon Simple Notification Service", Endpoints: []string{"sns.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "sqs", Description: "Amazon Simple Queue Service", Endpoints: []string{"sqs.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "ssm", Description: "AWS Systems Manager", Endpoints: []string{"ssm.{region}.amazonaws.com"}, ContentType: contentTypeAmz11},
+	{Name: "states", Description: "AWS Step Functions", Endpoints: []string{"states.{region}.amazonaws.com"}, ContentType: contentTypeAmzJSON},
+	{Name: "sts", Description: "AWS Security Token Service", Endpoints: []string{"sts.amazonaws.com", "sts.{region}.amazonaws.com"}, ContentType: contentTypeQuery},
+	{Name: "timestream", Description: "Amazon Timestream", Endpoints: []string{"*.timestream.{region}.amazonaws.com"}, ContentType: contentTypeAmzJSON},
+	{Name: "vpc-lattice-svcs", Description: "Amazon VPC Lattice services", Endpoints: []string{"*.vpc-lattice-svcs.{region}.on.aws"}, UnsignedPayload: true},
+}
+
+// serviceByName returns the known AWS service with the given signing name
+func serviceByName(name string) (awsService, bool) {
+	for _, s := range knownServices {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return awsService{}, false
+}
+
+// servicePattern is the compiled host regexp for one of the service endpoints
+type servicePattern struct {
+	service awsService
+	host    *regexp.Regexp
+}
+
+var servicePatterns = compileServicePatterns(knownServices)
+
+func compileServicePatterns(services []awsService) []servicePattern {
+	var patterns []servicePattern
+	for _, s := range services {
+		for _, e := range s.Endpoints {
+			p := regexp.QuoteMeta(e)
+			p = strings.Replace(p, `\*`, `.+`, -1)
+			p = strings.Replace(p, `\{region\}`, `(?P<region>[a-z0-9-]+)`, -1)
+			patterns = append(patterns, servicePattern{service: s, host: regexp.MustCompile("^" + p + "$")})
+		}
+	}
+	return patterns
+}
+
+const (
+	// defaultDNSSuffix is the DNS suffix of the endpoints in the standard AWS partition
+	defaultDNSSuffix = "amazonaws.com"
+	// globalRegion is the region to sign the requests to the global endpoints of the standard partition,
+	// i.e. the ones without the region in the host, like iam.amazonaws.com
+	globalRegion = "us-east-1"
+)
+
+// regionPattern matches the AWS region names, e.g. "us-east-1", "us-gov-west-1" or "us-isob-east-1"
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+$`)
+
+// detectService returns the AWS service and the region matching the given host.
+// The known services are matched by their endpoint patterns. For any other host in the
+// "<service>.<region>.amazonaws.com" or "<name>.<region>.<service>.amazonaws.com" format, the service name is taken
+// from the host as is. The region is empty if it's unknown, e.g. for global endpoints in other partitions.
+func detectService(host, dnsSuffix string) (awsService, string, bool) {
+	dnsSuffix = strings.ToLower(strings.Trim(dnsSuffix, "."))
+	host = normalizeEndpointHost(host, dnsSuffix)
+
+	for _, p := range servicePatterns {
+		m := p.host.FindStringSubmatch(host)
+		if m == nil {
+			continue
+		}
+		if i := p.host.SubexpIndex("region"); i > 0 {
+			return p.service, m[i], true
+		}
+		if dnsSuffix == "" || dnsSuffix == defaultDNSSuffix {
+			return p.service, globalRegion, true
+		}
+		return p.service, "", true
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, "."+defaultDNSSuffix), ".")
+	if !strings.HasSuffix(host, "."+defaultDNSSuffix) || len(labels) < 2 {
+		return awsService{}, "", false
+	}
+	n := len(labels)
+	switch {
+	case regionPattern.MatchString(labels[n-1]):
+		return awsService{Name: labels[n-2]}, labels[n-1], true
+	case n >= 3 && regionPattern.MatchString(labels[n-2]):
+		return awsService{Name: labels[n-1]}, labels[n-2], true
+	}
+	return awsService{}, "", false
+}
+
+// resolveService returns the service and the region to sign the request to the host for. The service is taken from
+// --service, then --aws-sigv4, then AWSCURL_SERVICE, then the host, then the config file or the built-in default.
+// The region is taken from --region, then --aws-sigv4, then the host. The empty region stands for the one of the AWS
+// config (AWS_REGION, AWS_DEFAULT_REGION or the profile one), which is often set for the whole shell, so it doesn't
+// win over the host telling exactly where the endpoint is.
+func resolveService(cmd *cobra.Command, host, sigV4Service, sigV4Region string) (string, string) {
+	service := flags.awsService
+	detected, region, ok := detectService(host, flags.dnsSuffix)
+	if ok && detected.Name != "" {
+		service = detected.Name
+	}
+	if env := os.Getenv("AWSCURL_SERVICE"); env != "" {
+		service = env
+	}
+	if sigV4Service != "" {
+		service = sigV4Service
+	}
+	if sigV4Region != "" {
+		region = sigV4Region
+	}
+
+	if cmd.Flags().Changed("service") {
+		service = flags.awsService
+	}
+	if cmd.Flags().Changed("region") {
+		region = ""
+	}
+	return service, region
+}
+
+// normalizeEndpointHost lowercases the host and reduces the endpoint variants to the standard one,
+// so they are matched by the same patterns:
+// - the non-standard DNS suffix (e.g. in an isolated partition) is replaced with the default one;
+// - dual-stack endpoints lose the "dualstack" label, e.g. s3.dualstack.us-east-1.amazonaws.com;
+// - FIPS endpoints lose the "-fips" suffix of the service label, e.g. sqs-fips.us-east-1.amazonaws.com.
+func normalizeEndpointHost(host, dnsSuffix string) string {
+	host = strings.ToLower(host)
+	if dnsSuffix != "" && strings.HasSuffix(host, "."+dnsSuffix) {
+		host = strings.TrimSuffix(host, dnsSuffix) + defaultDNSSuffix
+	}
+
+	var labels []string
+	for _, l := range strings.Split(host, ".") {
+		if l != "dualstack" {
+			labels = append(labels, strings.TrimSuffix(l, "-fips"))
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// servicesCmd prints the list of known AWS services
+var servicesCmd = &cobra.Command{
+	Use:   "services",
+	Short: "List known AWS services and their signing names",
+	Long: `List AWS services recognized by awscurl.
+The SERVICE column contains the value to use with the --service flag.
+It is detected automatically if the URL host matches one of the listed endpoints.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printServices(knownServices)
+	},
+}
+
+func printServices(services []awsService) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tDESCRIPTION\tENDPOINTS")
+	for _, s := range services {
+		for i, e := range s.Endpoints {
+			if i == 0 {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", s.Name, s.Description, e)
+			} else {
+				fmt.Fprintf(w, "\t\t%s\n", e)
+			}
+		}
+	}
+	w.Flush()
+}
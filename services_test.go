@@ -0,0 +1,143 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectService(t *testing.T) {
+	tests := []struct {
+		host      string
+		dnsSuffix string
+		service   string
+		region    string
+		ok        bool
+	}{
+		// The newer data-plane services, whose signing names differ from the hosts
+		{host: "cassandra.us-east-1.amazonaws.com", service: "cassandra", region: "us-east-1", ok: true},
+		{host: "query.timestream.us-east-1.amazonaws.com", service: "timestream", region: "us-east-1", ok: true},
+		{host: "ingest-cell2.timestream.eu-west-1.amazonaws.com", service: "timestream", region: "eu-west-1", ok: true},
+		{host: "g-abc123.grafana-workspace.us-west-2.amazonaws.com", service: "grafana", region: "us-west-2", ok: true},
+		{host: "aps-workspaces.eu-central-1.amazonaws.com", service: "aps", region: "eu-central-1", ok: true},
+		{host: "bedrock-runtime.us-east-1.amazonaws.com", service: "bedrock", region: "us-east-1", ok: true},
+		{host: "runtime.sagemaker.us-east-1.amazonaws.com", service: "sagemaker", region: "us-east-1", ok: true},
+		{host: "abc123.lambda-url.us-east-1.on.aws", service: "lambda", region: "us-east-1", ok: true},
+		{host: "my-service-0abc.7d67968.vpc-lattice-svcs.us-west-2.on.aws", service: "vpc-lattice-svcs", region: "us-west-2", ok: true},
+		{host: "api.ecr.us-east-1.amazonaws.com", service: "ecr", region: "us-east-1", ok: true},
+		{host: "email.us-east-1.amazonaws.com", service: "ses", region: "us-east-1", ok: true},
+
+		// The older ones
+		{host: "abc123.execute-api.us-east-1.amazonaws.com", service: "execute-api", region: "us-east-1", ok: true},
+		{host: "search-test-abc.eu-west-1.es.amazonaws.com", service: "es", region: "eu-west-1", ok: true},
+		{host: "streams.dynamodb.us-east-1.amazonaws.com", service: "dynamodb", region: "us-east-1", ok: true},
+		{host: "bucket.s3.amazonaws.com", service: "s3", region: globalRegion, ok: true},
+		{host: "bucket.s3-us-west-2.amazonaws.com", service: "s3", region: "us-west-2", ok: true},
+		{host: "iam.amazonaws.com", service: "iam", region: globalRegion, ok: true},
+
+		// The endpoint variants
+		{host: "S3.DualStack.US-EAST-1.amazonaws.com", service: "s3", region: "us-east-1", ok: true},
+		{host: "sqs-fips.us-gov-west-1.amazonaws.com", service: "sqs", region: "us-gov-west-1", ok: true},
+		{host: "dynamodb.cn-north-1.amazonaws.com.cn", dnsSuffix: "amazonaws.com.cn", service: "dynamodb", region: "cn-north-1", ok: true},
+		{host: "iam.amazonaws.com.cn", dnsSuffix: "amazonaws.com.cn", service: "iam", region: "", ok: true},
+
+		// The unknown services are taken from the host as is
+		{host: "newservice.us-east-1.amazonaws.com", service: "newservice", region: "us-east-1", ok: true},
+		{host: "name.ap-south-1.newservice.amazonaws.com", service: "newservice", region: "ap-south-1", ok: true},
+
+		{host: "example.com", ok: false},
+		{host: "amazonaws.com", ok: false},
+		{host: "localhost", ok: false},
+	}
+
+	for _, tt := range tests {
+		dnsSuffix := tt.dnsSuffix
+		if dnsSuffix == "" {
+			dnsSuffix = defaultDNSSuffix
+		}
+		service, region, ok := detectService(tt.host, dnsSuffix)
+		if service.Name != tt.service || region != tt.region || ok != tt.ok {
+			t.Errorf("detectService(%q) = %q, %q, %v, want %q, %q, %v", tt.host, service.Name, region, ok, tt.service, tt.region, tt.ok)
+		}
+	}
+}
+
+func TestKnownServicesEndpoints(t *testing.T) {
+	// Every service must be detected by its endpoints, so a new entry of the table can't be shadowed by another one
+	for _, s := range knownServices {
+		if len(s.Endpoints) == 0 {
+			t.Errorf("%s has no endpoints", s.Name)
+		}
+		for _, e := range s.Endpoints {
+			host := strings.NewReplacer("*", "example", "{region}", "us-east-1").Replace(e)
+			if detected, _, _ := detectService(host, defaultDNSSuffix); detected.Name != s.Name {
+				t.Errorf("%s is detected as %q, want %q", host, detected.Name, s.Name)
+			}
+		}
+	}
+}
+
+func TestLatticeSigning(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		scope       string
+		payloadHash string
+	}{
+		// The custom domain names of the Lattice services are not detected
+		{name: "vpc-lattice-svcs", args: []string{"--service", "vpc-lattice-svcs"}, scope: "/us-east-1/vpc-lattice-svcs/aws4_request", payloadHash: unsignedPayload},
+		// The payload is signed, while its hash isn't sent
+		{name: "other service", args: []string{"--service", "execute-api"}, scope: "/us-east-1/execute-api/aws4_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSigV4Verifier(t, testCredentials)
+			_, err := runAwscurl(t, append(tt.args, "-d", "{}", server.URL+"/items")...)
+			received := server.lastSignedRequest(t, err)
+			if authorization := received.Header.Get("Authorization"); !strings.Contains(authorization, tt.scope) {
+				t.Errorf("Authorization = %q, want the scope %s", authorization, tt.scope)
+			}
+			if hash := received.Header.Get(contentSHA256Header); hash != tt.payloadHash {
+				t.Errorf("%s = %q, want %q", contentSHA256Header, hash, tt.payloadHash)
+			}
+			if string(received.body) != "{}" {
+				t.Errorf("body = %q, want the payload", received.body)
+			}
+		})
+	}
+}
+
+func TestResolveService(t *testing.T) {
+	tests := []struct {
+		name                      string
+		args                      []string
+		host                      string
+		env                       string
+		sigV4Service, sigV4Region string
+		wantService, wantRegion   string
+	}{
+		{name: "detected", host: "search-test.eu-west-1.es.amazonaws.com", wantService: "es", wantRegion: "eu-west-1"},
+		{name: "default", host: "api.example.test", wantService: "execute-api"},
+		{name: "environment", host: "api.example.test", env: "es", wantService: "es"},
+		// AWSCURL_SERVICE is set by the user, so it wins over the detected service, but not over the region of the host
+		{name: "environment wins over the host", host: "search-test.eu-west-1.es.amazonaws.com", env: "aoss", wantService: "aoss", wantRegion: "eu-west-1"},
+		{name: "--aws-sigv4 wins over the environment", host: "api.example.test", env: "es", sigV4Service: "lambda", sigV4Region: "us-west-2", wantService: "lambda", wantRegion: "us-west-2"},
+		{name: "flags win", args: []string{"--service", "s3", "--region", "us-west-2"}, host: "search-test.eu-west-1.es.amazonaws.com", env: "aoss", sigV4Service: "lambda", sigV4Region: "ap-south-1", wantService: "s3"},
+	}
+
+	defer resetFlags(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFlags(t)
+			if err := rootCmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("AWSCURL_SERVICE", tt.env)
+
+			service, region := resolveService(rootCmd, tt.host, tt.sigV4Service, tt.sigV4Region)
+			if service != tt.wantService || region != tt.wantRegion {
+				t.Errorf("resolveService() = %q, %q, want %q, %q", service, region, tt.wantService, tt.wantRegion)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/legal90/awscurl/pkg/awscurl"
+)
+
+// The requests are signed by the awscurl package, the same way as by the Go code using it
+
+const (
+	amzDateHeader = awscurl.AmzDateHeader
+	amzDateFormat = awscurl.AmzDateFormat
+
+	// contentSHA256Header carries the payload hash, so the service can verify the payload wasn't modified
+	contentSHA256Header = awscurl.ContentSHA256Header
+	// unsignedPayload is used instead of the payload hash by the services which don't support the payload signing
+	unsignedPayload = awscurl.UnsignedPayload
+
+	// requestPayerHeader confirms the requester agrees to pay for the access to S3 Requester Pays buckets
+	requestPayerHeader = "X-Amz-Request-Payer"
+)
+
+// parseSigningDate parses the --date value in RFC3339 or in the ISO8601 basic format used in X-Amz-Date.
+// The empty value stands for the current time and gives the zero time.
+func parseSigningDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, amzDateFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf(`Error: Invalid --date value: %s. It should be in RFC3339 ("2024-01-02T15:04:05Z") or ISO8601 basic ("20240102T150405Z") format`, value)
+}
+
+// newAWSClient returns the client signing the requests to the service in the region of the config, the same way
+// for the single request and the bulk ones: with --date-header, --date, --sigv4a and --region-set,
+// and without the payload hash for the services which don't support it
+func newAWSClient(cfg aws.Config, service string, signingDate time.Time) (*awscurl.Client, error) {
+	client := &awscurl.Client{
+		Config:          cfg,
+		Service:         service,
+		DateHeader:      flags.dateHeader,
+		SigV4A:          flags.sigV4A,
+		Time:            signingDate,
+		NoContentSHA256: flags.noContentSHA256,
+	}
+	if known, ok := serviceByName(service); ok {
+		client.UnsignedPayload = known.UnsignedPayload
+	}
+	if flags.regionSet != "" {
+		if !flags.sigV4A {
+			return nil, fmt.Errorf("Error: --region-set could be used only together with --sigv4a")
+		}
+		regionSet, err := parseRegionSet(flags.regionSet)
+		if err != nil {
+			return nil, err
+		}
+		client.Region = regionSet
+	}
+	return client, nil
+}
+
+// newRequestSigner returns the signer of the request with the body, along with the credentials and the payload hash
+// it's signed with. X-Amz-Content-Sha256 is set here, so it's signed as any other header.
+// The streaming body is signed with the special payload hash, as it's signed chunk by chunk.
+func newRequestSigner(cfg aws.Config, signingName string, signingDate time.Time, req *http.Request, body []byte, streaming bool) (*awscurl.Signer, aws.Credentials, string, error) {
+	awsClient, err := newAWSClient(cfg, signingName, signingDate)
+	if err != nil {
+		return nil, aws.Credentials{}, "", err
+	}
+	payloadHash := awsClient.PayloadHash(body)
+	if streaming {
+		payloadHash = streamingPayload
+	}
+	if sendContentSHA256(signingName, payloadHash) {
+		req.Header.Set(contentSHA256Header, payloadHash)
+	}
+
+	creds, err := retrieveCredentials(cfg)
+	if err != nil {
+		return nil, creds, "", err
+	}
+	return awsClient.NewSigner(creds), creds, payloadHash, nil
+}
+
+// sendContentSHA256 reports whether the payload hash is sent in X-Amz-Content-Sha256. S3 requires the header,
+// and the services get it when the hash is a special value, as they can't tell it from the signature otherwise.
+// The other services compute the payload hash themselves.
+func sendContentSHA256(signingName, payloadHash string) bool {
+	if flags.noContentSHA256 {
+		return false
+	}
+	return signingName == "s3" || signingName == "s3-object-lambda" || payloadHash == unsignedPayload || payloadHash == streamingPayload
+}
+
+// maxPresignExpiry is the longest validity of a presigned URL allowed by SigV4
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// presign returns the URL of the request with the signature in the query, valid for the given duration.
+// The request itself is not modified.
+func presign(signer *awscurl.Signer, req *http.Request, payloadHash string, expires time.Duration) (string, error) {
+	if expires <= 0 || expires > maxPresignExpiry {
+		return "", fmt.Errorf("Error: Invalid --expires value: %s. It should be positive and not longer than %s", expires, maxPresignExpiry)
+	}
+
+	signedURL, signedHeaders, err := signer.Presign(req, payloadHash, expires)
+	if err != nil {
+		return "", err
+	}
+
+	// The headers added with -H are signed as well, so the URL is valid only if they are sent along with it
+	var required []string
+	for name := range signedHeaders {
+		if !strings.EqualFold(name, "Host") {
+			required = append(required, name)
+		}
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		fmt.Fprintf(diagnostics, "Warning: The URL is signed with the headers, which must be sent along with it: %s\n", strings.Join(required, ", "))
+	}
+
+	return signedURL, nil
+}
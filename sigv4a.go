@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseRegionSet validates the --region-set value and returns it normalized, e.g. "us-east-1, eu-*" gives "us-east-1,eu-*"
+func parseRegionSet(value string) (string, error) {
+	var regions []string
+	for _, region := range strings.Split(value, ",") {
+		region = strings.ToLower(strings.TrimSpace(region))
+		valid := region != ""
+		for _, c := range region {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '*') {
+				valid = false
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf(`Error: Invalid --region-set value: %s. It should be a comma-separated list of regions, e.g. "us-east-1,eu-west-1" or "*"`, value)
+		}
+		regions = append(regions, region)
+	}
+	return strings.Join(regions, ","), nil
+}
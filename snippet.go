@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxInlineSnippetBody is the size of the largest payload put to the command line as is.
+// Any larger or binary payload is written to a temporary file, which the command reads it from.
+// The path of the file is reported in a comment line before the command, so it could be removed after use.
+const maxInlineSnippetBody = 1024
+
+// snippetFormats are the supported --snippet formats
+var snippetFormats = map[string]func(w io.Writer, req *http.Request, body []byte) error{
+	"curl":   writeCurlSnippet,
+	"httpie": writeHTTPieSnippet,
+}
+
+func snippetFormatNames() []string {
+	var names []string
+	for name := range snippetFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeSnippet writes the command reproducing the signed request in the given format
+func writeSnippet(w io.Writer, format string, req *http.Request, body []byte) error {
+	write, ok := snippetFormats[format]
+	if !ok {
+		return fmt.Errorf("Error: Unsupported snippet format: %s. Supported formats: %s", format, strings.Join(snippetFormatNames(), ", "))
+	}
+
+	fmt.Fprintf(w, "# The signature is time-limited: the request must be sent within a few minutes after %s\n", signingTimeOf(req))
+	return write(w, req, body)
+}
+
+// signingTimeOf returns the signing timestamp of the request as it's set in the headers
+func signingTimeOf(req *http.Request) string {
+	if t := req.Header.Get(amzDateHeader); t != "" {
+		return t
+	}
+	return req.Header.Get("Date")
+}
+
+// snippetHeaders returns the request headers as "Name: Value" lines in the sorted order
+func snippetHeaders(req *http.Request, sep string) []string {
+	var headers []string
+	if req.Host != "" && req.Host != req.URL.Host {
+		headers = append(headers, "Host"+sep+req.Host)
+	}
+	for name, values := range req.Header {
+		for _, v := range values {
+			headers = append(headers, name+sep+v)
+		}
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+func writeCurlSnippet(w io.Writer, req *http.Request, body []byte) error {
+	lines := []string{"curl -X " + req.Method}
+	// With "-X HEAD" curl would wait for the response body, which never comes
+	if req.Method == http.MethodHead {
+		lines = []string{"curl -I"}
+	}
+	for _, h := range snippetHeaders(req, ": ") {
+		lines = append(lines, "-H "+shellQuote(h))
+	}
+	if len(body) > 0 {
+		data, err := snippetData(w, body)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "--data-binary "+data)
+	}
+	lines = append(lines, shellQuote(req.URL.String()))
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, " \\\n  "))
+	return err
+}
+
+func writeHTTPieSnippet(w io.Writer, req *http.Request, body []byte) error {
+	lines := []string{"http --ignore-stdin " + req.Method + " " + shellQuote(req.URL.String())}
+	for _, h := range snippetHeaders(req, ":") {
+		lines = append(lines, shellQuote(h))
+	}
+	if len(body) > 0 {
+		lines = append(lines, "--raw "+shellQuote(string(body)))
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, " \\\n  "))
+	return err
+}
+
+// snippetData returns the payload quoted for the shell, or the reference to the temporary file with it
+// in the curl @file format, if the payload is too large or not printable. The path of the file is written to w.
+func snippetData(w io.Writer, body []byte) (string, error) {
+	if len(body) <= maxInlineSnippetBody && isPrintable(body) {
+		return shellQuote(string(body)), nil
+	}
+
+	f, err := ioutil.TempFile("", "awscurl-body-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(body); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(w, "# payload saved to %s\n", f.Name())
+	return shellQuote("@" + f.Name()), nil
+}
+
+// isPrintable reports whether the data is a valid UTF-8 text without control characters other than whitespaces
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// shellQuote quotes the string for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// writeVerboseResponse writes the status line with the negotiated protocol and the response headers, as curl -v does
+func writeVerboseResponse(w io.Writer, response *http.Response) {
+	fmt.Fprintf(w, "< %s %s\n", response.Proto, response.Status)
+	for _, h := range snippetHeaders(&http.Request{Header: response.Header}, ": ") {
+		fmt.Fprintf(w, "< %s\n", h)
+	}
+	fmt.Fprintln(w, "<")
+}
+
+// writeVerboseRequest writes the signed request line, the sorted headers and the payload hash used for signing
+// in the curl --verbose style. The signed request never contains the secret key.
+func writeVerboseRequest(w io.Writer, req *http.Request, payloadHash string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	fmt.Fprintf(w, "> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+	fmt.Fprintf(w, "> Host: %s\n", host)
+	// Content-Length is signed as well, but it's not a part of req.Header
+	header := req.Header.Clone()
+	if req.ContentLength > 0 {
+		header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	for _, h := range snippetHeaders(&http.Request{URL: req.URL, Header: header}, ": ") {
+		fmt.Fprintf(w, "> %s\n", h)
+	}
+	fmt.Fprintln(w, ">")
+	fmt.Fprintf(w, "* Payload SHA256: %s\n", payloadHash)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCurlSnippetPayloadFile(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	tests := []struct {
+		name     string
+		body     string
+		wantFile bool
+	}{
+		{name: "inline", body: `{"key": "value"}`},
+		{name: "large", body: strings.Repeat("a", maxInlineSnippetBody+1), wantFile: true},
+		{name: "binary", body: "\x00\x01\x02", wantFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "https://example.com/items", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var snippet bytes.Buffer
+			if err := writeCurlSnippet(&snippet, req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+
+			first := strings.SplitN(snippet.String(), "\n", 2)[0]
+			path := strings.TrimPrefix(first, "# payload saved to ")
+			if !tt.wantFile {
+				if path != first {
+					t.Errorf("snippet reports the payload file for the inline payload:\n%s", snippet.String())
+				}
+				return
+			}
+			if path == first {
+				t.Fatalf("snippet doesn't report the payload file:\n%s", snippet.String())
+			}
+			if !strings.Contains(snippet.String(), "--data-binary '@"+path+"'") {
+				t.Errorf("snippet doesn't read the payload from %s:\n%s", path, snippet.String())
+			}
+			saved, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(saved) != tt.body {
+				t.Errorf("payload file = %q, want %q", saved, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptrace"
+	"time"
+)
+
+// requestTimings collects the timestamps of the request phases
+type requestTimings struct {
+	start        time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+	done         time.Time
+}
+
+// clientTrace returns the httptrace hooks recording the timings.
+// It is supposed to be attached to the request context with httptrace.WithClientTrace
+func (t *requestTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { t.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { t.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.tlsDone = time.Now() },
+		GotFirstResponseByte: func() { t.firstByte = time.Now() },
+	}
+}
+
+// phase returns the duration between two timestamps, or zero if the phase didn't happen (e.g. no TLS for http://)
+func phase(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+func (t *requestTimings) dns() time.Duration     { return phase(t.dnsStart, t.dnsDone) }
+func (t *requestTimings) connect() time.Duration { return phase(t.connectStart, t.connectDone) }
+func (t *requestTimings) tls() time.Duration     { return phase(t.tlsStart, t.tlsDone) }
+func (t *requestTimings) ttfb() time.Duration    { return phase(t.start, t.firstByte) }
+func (t *requestTimings) total() time.Duration   { return phase(t.start, t.done) }
+
+// timingReport is written by --timing-json
+type timingReport struct {
+	DNSMs      float64 `json:"dns_ms"`
+	ConnectMs  float64 `json:"connect_ms"`
+	TLSMs      float64 `json:"tls_ms"`
+	TTFBMs     float64 `json:"ttfb_ms"`
+	TotalMs    float64 `json:"total_ms"`
+	StatusCode int     `json:"status_code"`
+	// SizeDownload is the number of the response body bytes received
+	SizeDownload int64 `json:"size_download"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func newTimingReport(t *requestTimings, statusCode int, sizeDownload int64) timingReport {
+	return timingReport{
+		DNSMs:        milliseconds(t.dns()),
+		ConnectMs:    milliseconds(t.connect()),
+		TLSMs:        milliseconds(t.tls()),
+		TTFBMs:       milliseconds(t.ttfb()),
+		TotalMs:      milliseconds(t.total()),
+		StatusCode:   statusCode,
+		SizeDownload: sizeDownload,
+	}
+}
+
+// writeTimingJSON writes the request timings as a JSON object to the file at the given path
+func writeTimingJSON(path string, t *requestTimings, statusCode int, sizeDownload int64) error {
+	data, err := json.Marshal(newTimingReport(t, statusCode, sizeDownload))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+)
+
+// redactedPatterns match the secrets in the traced requests, the group 2 is replaced with --trace-redact
+var redactedPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(Signature=)([0-9a-f]+)`),
+	regexp.MustCompile(`(?i)(X-Amz-Security-Token: )([^\r\n]+)`),
+	regexp.MustCompile(`(?i)(X-Amz-Security-Token=)([^&\s]+)`),
+}
+
+// tracingTransport writes every request and response going through it to w, as curl --trace and --trace-ascii do.
+// Every single attempt is written, including the retries and the redirects.
+type tracingTransport struct {
+	next http.RoundTripper
+	w    io.Writer
+	// ascii writes the data as text lines instead of the hex dump
+	ascii bool
+	// redact hides the signature and the session token
+	redact bool
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Only the headers are dumped, the body is traced as it's sent. Reading it for the dump would buffer it whole,
+	// e.g. the large file streamed with aws-chunked. The client trace of the request must not see the dump.
+	dump, err := httputil.DumpRequestOut(req.WithContext(context.Background()), false)
+	if err != nil {
+		return nil, err
+	}
+	t.write("=> Send header", dump)
+
+	sent := req
+	if req.Body != nil && req.Body != http.NoBody {
+		sent = req.WithContext(req.Context())
+		sent.Body = &tracingBody{body: req.Body, trace: t, title: "=> Send data"}
+	}
+	response, err := t.next.RoundTrip(sent)
+	if err != nil {
+		fmt.Fprintf(t.w, "== Info: %s\n", err)
+		return nil, err
+	}
+
+	dump, err = httputil.DumpResponse(response, false)
+	if err != nil {
+		return nil, err
+	}
+	t.write("<= Recv header", dump)
+	response.Body = &tracingBody{body: response.Body, trace: t, title: "<= Recv data"}
+	return response, nil
+}
+
+// tracingBody writes the data of the body as it's read, so the body is traced without being buffered
+type tracingBody struct {
+	body  io.ReadCloser
+	trace *tracingTransport
+	title string
+}
+
+func (b *tracingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.trace.write(b.title, p[:n])
+	}
+	return n, err
+}
+
+func (b *tracingBody) Close() error {
+	return b.body.Close()
+}
+
+// write writes the titled data in the hex or ascii format
+func (t *tracingTransport) write(title string, data []byte) {
+	if t.redact {
+		for _, pattern := range redactedPatterns {
+			data = pattern.ReplaceAll(data, []byte("${1}[REDACTED]"))
+		}
+	}
+
+	fmt.Fprintf(t.w, "%s, %d bytes (0x%x)\n", title, len(data), len(data))
+	if !t.ascii {
+		io.WriteString(t.w, hex.Dump(data))
+		return
+	}
+
+	// Every line is prefixed with its offset, the same as in curl --trace-ascii
+	offset := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		fmt.Fprintf(t.w, "%04x: %s\n", offset, bytes.TrimSuffix(line, []byte("\r")))
+		offset += len(line) + 1
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc is the http.RoundTripper calling the function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestTracingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Received", string(body))
+		w.Write([]byte("response body"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		ascii  bool
+		redact bool
+		want   []string
+		absent []string
+	}{
+		{
+			name:  "ascii",
+			ascii: true,
+			want: []string{"=> Send header", "0000: POST /upload HTTP/1.1", "X-Amz-Security-Token: token",
+				"=> Send data, 12 bytes (0xc)", "0000: request body", "<= Recv header", "X-Received: request body",
+				"<= Recv data, 13 bytes (0xd)", "0000: response body"},
+		},
+		{
+			name: "hex",
+			want: []string{"=> Send header", "=> Send data, 12 bytes (0xc)", "72 65 71 75 65 73 74 20  62 6f 64 79", "<= Recv data"},
+		},
+		{
+			name:   "redacted",
+			ascii:  true,
+			redact: true,
+			want:   []string{"X-Amz-Security-Token: [REDACTED]"},
+			absent: []string{"token\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trace strings.Builder
+			body := &countingReader{Reader: strings.NewReader("request body")}
+			next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				// The body is streamed as it's sent, rather than read before for the trace
+				if body.read != 0 {
+					t.Errorf("%d bytes of the body are read before the request is sent", body.read)
+				}
+				return http.DefaultTransport.RoundTrip(req)
+			})
+			transport := &tracingTransport{next: next, w: &trace, ascii: tt.ascii, redact: tt.redact}
+
+			req, _ := http.NewRequest(http.MethodPost, server.URL+"/upload", ioutil.NopCloser(body))
+			req.ContentLength = int64(len("request body"))
+			req.Header.Set("X-Amz-Security-Token", "token")
+			response, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			received, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if string(received) != "response body" {
+				t.Errorf("response body = %q, want %q", received, "response body")
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(trace.String(), want) {
+					t.Errorf("the trace doesn't contain %q:\n%s", want, trace.String())
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(trace.String(), absent) {
+					t.Errorf("the trace contains %q:\n%s", absent, trace.String())
+				}
+			}
+		})
+	}
+}
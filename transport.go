@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	urls "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/http2"
+)
+
+// dialContextFunc is the signature of http.Transport.DialContext
+type dialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// hostResolver resolves hosts for the dialer. It bounds the DNS lookup time and,
+// unless disabled, resolves each host only once and reuses its addresses for all subsequent connections.
+type hostResolver struct {
+	mu sync.Mutex
+	// cache is nil if caching is disabled
+	cache   map[string][]string
+	timeout time.Duration
+	// static are the addresses given with --resolve for "host:port", they are never looked up
+	static map[string][]string
+	// connectTo are the --connect-to rules, the first matching one replaces the host and the port to connect to
+	connectTo []connectToRule
+	// network is "tcp4" or "tcp6" to connect only to the addresses of that family, as -4 and -6 do. Empty means both
+	network string
+}
+
+// connectToRule connects to toHost:toPort instead of fromHost:fromPort.
+// Empty "from" parts match any host or port, empty "to" parts keep the original ones.
+type connectToRule struct {
+	fromHost, fromPort string
+	toHost, toPort     string
+}
+
+// apply returns the address to connect to instead of the given host and port, if the rule matches them
+func (c connectToRule) apply(host, port string) (string, bool) {
+	if (c.fromHost != "" && !strings.EqualFold(c.fromHost, host)) || (c.fromPort != "" && c.fromPort != port) {
+		return "", false
+	}
+	if c.toHost != "" {
+		host = c.toHost
+	}
+	if c.toPort != "" {
+		port = c.toPort
+	}
+	return net.JoinHostPort(host, port), true
+}
+
+func newHostResolver(cache bool, timeout time.Duration) *hostResolver {
+	r := &hostResolver{timeout: timeout}
+	if cache {
+		r.cache = make(map[string][]string)
+	}
+	return r
+}
+
+// lookup returns the addresses of the host. The lock guards only the cache, so a slow lookup never blocks
+// the connections to the other hosts. Concurrent lookups of the same host could both go to DNS, the last one is cached.
+func (r *hostResolver) lookup(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	addrs, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok {
+		return addrs, nil
+	}
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	// LookupIPAddr reports the DNS phase to httptrace, so the DNS timing is still available
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("DNS lookup of %s timed out after %s", host, r.timeout)
+		}
+		return nil, err
+	}
+	for _, ip := range ipAddrs {
+		addrs = append(addrs, ip.String())
+	}
+	if r.cache != nil {
+		r.mu.Lock()
+		r.cache[host] = addrs
+		r.mu.Unlock()
+	}
+
+	return addrs, nil
+}
+
+// dialContext wraps the given dial function so it connects to the addresses resolved by hostResolver
+func (r *hostResolver) dialContext(dial dialContextFunc) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		for _, rule := range r.connectTo {
+			if to, ok := rule.apply(host, port); ok {
+				addr = to
+				host, port, _ = net.SplitHostPort(addr)
+				break
+			}
+		}
+		if r.network != "" {
+			network = r.network
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if !matchesNetwork(ip, network) {
+				return nil, fmt.Errorf("%s is not an %s address", host, networkFamily(network))
+			}
+			return dial(ctx, network, addr)
+		}
+
+		addrs, ok := r.static[strings.ToLower(net.JoinHostPort(host, port))]
+		if !ok {
+			if addrs, err = r.lookup(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+		var matching []string
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil && matchesNetwork(ip, network) {
+				matching = append(matching, a)
+			}
+		}
+		if len(matching) == 0 {
+			return nil, fmt.Errorf("%s has no %s address", host, networkFamily(network))
+		}
+		addrs = matching
+
+		// Try the addresses one by one, the same as net.Dialer does
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// matchesNetwork tells whether the IP address could be connected to over the network, e.g. only IPv4 ones over "tcp4"
+func matchesNetwork(ip net.IP, network string) bool {
+	switch network {
+	case "tcp4":
+		return ip.To4() != nil
+	case "tcp6":
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// networkFamily returns the address family of the network for the error messages
+func networkFamily(network string) string {
+	if network == "tcp6" {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// localAddrs are the addresses of the --interface to bind the outgoing connections to, one per address family
+type localAddrs struct {
+	name string
+	ipv4 net.IP
+	ipv6 net.IP
+}
+
+// parseInterface returns the local addresses for the --interface value: an IP address or a network interface name,
+// e.g. "eth1", which is bound with its first IPv4 and IPv6 addresses
+func parseInterface(value string) (localAddrs, error) {
+	local := localAddrs{name: value}
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")); ip != nil {
+		if ip.To4() != nil {
+			local.ipv4 = ip
+		} else {
+			local.ipv6 = ip
+		}
+		return local, nil
+	}
+
+	iface, err := net.InterfaceByName(value)
+	if err != nil {
+		return local, fmt.Errorf("Error: Invalid --interface value: %s. It should be an IP address or a network interface name: %s", value, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return local, fmt.Errorf("Error: Unable to get the addresses of the interface %s: %s", value, err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		// The link-local IPv6 addresses need the zone, they are never used to reach AWS anyway
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil && local.ipv4 == nil {
+			local.ipv4 = ipNet.IP
+		} else if ipNet.IP.To4() == nil && local.ipv6 == nil {
+			local.ipv6 = ipNet.IP
+		}
+	}
+	if local.ipv4 == nil && local.ipv6 == nil {
+		return local, fmt.Errorf("Error: The interface %s has no IP addresses", value)
+	}
+	return local, nil
+}
+
+// bind returns the dial function which connects from the local address of the same family as the remote one
+func (l localAddrs) bind(dialer *net.Dialer) dialContextFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		local, family := l.ipv4, "IPv4"
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			local, family = l.ipv6, "IPv6"
+		}
+		if local == nil {
+			return nil, fmt.Errorf("--interface %s has no %s address to connect to %s", l.name, family, host)
+		}
+
+		d := *dialer
+		d.LocalAddr = &net.TCPAddr{IP: local}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// parseResolve parses the --resolve entries in the curl format "host:port:address[,address...]"
+// to the map of "host:port" to the addresses. IPv6 addresses could be given in brackets, e.g. "[::1]".
+func parseResolve(entries []string) (map[string][]string, error) {
+	static := make(map[string][]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf(`Error: Invalid --resolve value: %s. It should be in the format "host:port:address", e.g. "example.com:443:127.0.0.1"`, entry)
+		}
+		if _, err := strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return nil, fmt.Errorf("Error: Invalid port in --resolve value: %s", entry)
+		}
+
+		var addrs []string
+		for _, a := range strings.Split(parts[2], ",") {
+			a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+			if net.ParseIP(a) == nil {
+				return nil, fmt.Errorf("Error: Invalid address in --resolve value: %s. It should be an IP address", entry)
+			}
+			addrs = append(addrs, a)
+		}
+		key := strings.ToLower(net.JoinHostPort(parts[0], parts[1]))
+		static[key] = append(static[key], addrs...)
+	}
+	return static, nil
+}
+
+// h2cRouter sends the plain http:// requests with HTTP/2 prior knowledge (h2c), and any other ones with the next transport.
+// The h2c connections are dialed the same way as the other ones, but they never go through a proxy.
+type h2cRouter struct {
+	h2c  *http2.Transport
+	next http.RoundTripper
+}
+
+func newH2CRouter(tr *http.Transport) *h2cRouter {
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return tr.DialContext(ctx, network, addr)
+		},
+	}
+	return &h2cRouter{h2c: h2c, next: tr}
+}
+
+func (r *h2cRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return r.h2c.RoundTrip(req)
+	}
+	return r.next.RoundTrip(req)
+}
+
+// parseConnectTo parses the --connect-to entries in the curl format "host1:port1:host2:port2".
+// IPv6 addresses should be given in brackets, e.g. "[::1]".
+func parseConnectTo(entries []string) ([]connectToRule, error) {
+	var rules []connectToRule
+	for _, entry := range entries {
+		parts := splitHostPorts(entry)
+		if len(parts) != 4 {
+			return nil, fmt.Errorf(`Error: Invalid --connect-to value: %s. It should be in the format "host1:port1:host2:port2", e.g. "example.com:443:vpce.example.com:443"`, entry)
+		}
+		for _, port := range []string{parts[1], parts[3]} {
+			if _, err := strconv.ParseUint(port, 10, 16); port != "" && err != nil {
+				return nil, fmt.Errorf("Error: Invalid port in --connect-to value: %s", entry)
+			}
+		}
+		rules = append(rules, connectToRule{fromHost: parts[0], fromPort: parts[1], toHost: parts[2], toPort: parts[3]})
+	}
+	return rules, nil
+}
+
+// splitHostPorts splits the string by colons, except the ones inside brackets, which are removed
+func splitHostPorts(s string) []string {
+	var parts []string
+	var part strings.Builder
+	inBrackets := false
+	for _, c := range s {
+		switch {
+		case c == '[':
+			inBrackets = true
+		case c == ']':
+			inBrackets = false
+		case c == ':' && !inBrackets:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// loadCABundle returns the pool of the CA certificates from the PEM file.
+// Only these certificates are trusted then, the system ones are not used.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error: Unable to read the CA bundle: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("Error: Unable to parse the CA bundle %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// loadClientCertificate loads the client certificate for mutual TLS.
+// If the key file is not given, the private key is expected in the certificate file.
+func loadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if keyFile == "" {
+		cert, err := tls.LoadX509KeyPair(certFile, certFile)
+		if err != nil {
+			return cert, fmt.Errorf("Error: Unable to load the client certificate %s: %s. If the private key is in a separate file, pass it with --key", certFile, err)
+		}
+		return cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, fmt.Errorf("Error: Unable to load the client certificate %s with the key %s: %s", certFile, keyFile, err)
+	}
+	return cert, nil
+}
+
+// explainTimeout replaces the timeout error with the one telling which of the timeouts is exceeded.
+// The overall --max-time is checked first, since it also interrupts the connection attempts.
+func explainTimeout(ctx context.Context, err error, connectTimeout, maxTime time.Duration) error {
+	if maxTime > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("Error: The operation timed out after %s (--max-time)", maxTime)
+	}
+
+	var opErr *net.OpError
+	if connectTimeout > 0 && errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout() {
+		return fmt.Errorf("Error: The connection to %s timed out after %s (--connect-timeout)", opErr.Addr, connectTimeout)
+	}
+	return err
+}
+
+// newTransport returns the transport configured with the connection, TLS and proxy flags, shared by the single request
+// and the bulk ones. The *http.Transport itself is returned along with the round tripper wrapping it, e.g. for h2c.
+func newTransport(cmd *cobra.Command) (*http.Transport, http.RoundTripper, error) {
+	var err error
+	// Set TLS Client configuration. AWS_CA_BUNDLE is honored the same way as by the AWS CLI
+	tlsConfig := &tls.Config{InsecureSkipVerify: flags.insecure}
+	caCert := flags.caCert
+	if caCert == "" {
+		caCert = os.Getenv("AWS_CA_BUNDLE")
+	}
+	if caCert != "" {
+		if tlsConfig.RootCAs, err = loadCABundle(caCert); err != nil {
+			return nil, nil, err
+		}
+	}
+	if flags.key != "" && flags.cert == "" {
+		return nil, nil, fmt.Errorf("Error: --key could be used only together with --cert")
+	}
+	if flags.cert != "" {
+		clientCert, err := loadClientCertificate(flags.cert, flags.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	tr := &http.Transport{
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   flags.tlsTimeout,
+		ResponseHeaderTimeout: flags.headerTimeout,
+	}
+
+	dialer := &net.Dialer{Timeout: flags.connectTimeout}
+	resolver := newHostResolver(!flags.noDNSCache, flags.dnsTimeout)
+	if resolver.static, err = parseResolve(flags.resolve); err != nil {
+		return nil, nil, err
+	}
+	if resolver.connectTo, err = parseConnectTo(flags.connectTo); err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case flags.ipv4 && flags.ipv6:
+		return nil, nil, fmt.Errorf("Error: Only one of --ipv4 and --ipv6 could be used")
+	case flags.ipv4:
+		resolver.network = "tcp4"
+	case flags.ipv6:
+		resolver.network = "tcp6"
+	}
+	dial := dialer.DialContext
+	if flags.networkInterface != "" {
+		local, err := parseInterface(flags.networkInterface)
+		if err != nil {
+			return nil, nil, err
+		}
+		dial = local.bind(dialer)
+	}
+	tr.DialContext = resolver.dialContext(dial)
+	if flags.unixSocket != "" {
+		// The URL host is still sent and signed, only the connection goes to the socket
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", flags.unixSocket)
+		}
+	}
+	if flags.limitRate != "" {
+		rate, err := parseRate(flags.limitRate)
+		if err != nil {
+			return nil, nil, err
+		}
+		tr.DialContext = limitRate(tr.DialContext, rate)
+	}
+
+	// The proxy is taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY, unless it's overridden with the flags
+	proxyConfig := httpproxy.FromEnvironment()
+	if flags.proxy != "" {
+		// The proxy without the scheme, e.g. "proxyhost:3128", is treated as the HTTP one
+		proxyConfig.HTTPProxy, proxyConfig.HTTPSProxy = flags.proxy, flags.proxy
+	}
+	if flagGiven(cmd, "noproxy") {
+		proxyConfig.NoProxy = flags.noProxy
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	// Authorization carries the AWS signature, so the Basic authentication is only possible for the proxy,
+	// in Proxy-Authorization. --proxy-user wins over the user in the proxy URL, which wins over .netrc.
+	var proxyUser *urls.Userinfo
+	if flags.proxyUser != "" {
+		parts := strings.SplitN(flags.proxyUser, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, nil, fmt.Errorf(`Error: Invalid --proxy-user value. It should be in the format "user:password"`)
+		}
+		proxyUser = urls.UserPassword(parts[0], parts[1])
+	}
+	netrcFile, err := netrcPath(flags.netrcFile, flags.netrc)
+	if err != nil {
+		return nil, nil, err
+	}
+	var proxyNetrc *netrc
+	if netrcFile != "" {
+		if proxyNetrc, err = readNetrc(netrcFile); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tr.Proxy = func(r *http.Request) (*urls.URL, error) {
+		if flags.unixSocket != "" {
+			return nil, nil
+		}
+		proxyURL, err := proxyFunc(r.URL)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		// The parsed proxy URL is shared by all the requests, so it's copied to set the user
+		withUser := *proxyURL
+		proxyURL = &withUser
+		switch {
+		case proxyUser != nil:
+			proxyURL.User = proxyUser
+		case proxyURL.User == nil && proxyNetrc != nil:
+			if login, ok := proxyNetrc.login(proxyURL.Hostname()); ok {
+				proxyURL.User = urls.UserPassword(login.login, login.password)
+			}
+		}
+		return proxyURL, nil
+	}
+
+	// HTTP/1.1 is used by default, as the transport has the custom TLS config and the dialer. HTTP/2 is only used with --http2
+	var transport http.RoundTripper = tr
+	switch {
+	case flags.http11 && flags.http2:
+		return nil, nil, fmt.Errorf("Error: Only one of --http1.1 and --http2 could be used")
+	case flags.http11:
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	case flags.http2:
+		if err := http2.ConfigureTransport(tr); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+		transport = newH2CRouter(tr)
+	}
+	return tr, transport, nil
+}
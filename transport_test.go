@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHostResolverLookupCache(t *testing.T) {
+	r := newHostResolver(true, 0)
+	r.cache["cached.invalid"] = []string{"192.0.2.1"}
+
+	// The cached hosts are never looked up, so the lookups don't depend on DNS
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := r.lookup(context.Background(), "cached.invalid")
+			if err != nil || !reflect.DeepEqual(addrs, []string{"192.0.2.1"}) {
+				t.Errorf("lookup() = %v, %v, want the cached address", addrs, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	addrs, err := r.lookup(context.Background(), "localhost")
+	if err != nil {
+		t.Skipf("localhost isn't resolved: %s", err)
+	}
+	if cached := r.cache["localhost"]; !reflect.DeepEqual(cached, addrs) {
+		t.Errorf("cache[localhost] = %v, want %v", cached, addrs)
+	}
+
+	uncached := newHostResolver(false, 0)
+	if _, err := uncached.lookup(context.Background(), "localhost"); err != nil {
+		t.Fatal(err)
+	}
+	if uncached.cache != nil {
+		t.Errorf("the resolver caches the addresses with the cache disabled")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "awscurl.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Unix domain sockets aren't supported: %s", err)
+	}
+	server := &sigV4Verifier{creds: testCredentials}
+	server.Server = httptest.NewUnstartedServer(http.HandlerFunc(server.serveHTTP))
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	// The host doesn't exist, the request gets to the server only through the socket
+	_, err = runAwscurl(t, "--unix-socket", socket, "http://api.example.test/items?a=1")
+	received := server.lastSignedRequest(t, err)
+	// The signature covers the URL host, not the socket path
+	if received.Host != "api.example.test" {
+		t.Errorf("Host = %q, want the URL host", received.Host)
+	}
+	if received.URL.RequestURI() != "/items?a=1" {
+		t.Errorf("request URI = %q, want the URL path and query", received.URL.RequestURI())
+	}
+}
+
+func TestHostResolverDialContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		static  []string
+		connect []string
+		network string
+		want    []string
+		wantErr bool
+	}{
+		{name: "ip address", addr: "127.0.0.1:80", want: []string{"127.0.0.1:80"}},
+		{name: "resolve", addr: "example.invalid:443", static: []string{"example.invalid:443:192.0.2.1,192.0.2.2"}, want: []string{"192.0.2.1:443", "192.0.2.2:443"}},
+		{name: "resolve other port", addr: "example.invalid:80", static: []string{"example.invalid:443:192.0.2.1"}, wantErr: true},
+		{name: "connect to", addr: "example.invalid:443", connect: []string{"example.invalid:443:192.0.2.3:8443"}, want: []string{"192.0.2.3:8443"}},
+		{name: "connect to then resolve", addr: "a.invalid:443", static: []string{"b.invalid:443:192.0.2.4"}, connect: []string{"a.invalid::b.invalid:"}, want: []string{"192.0.2.4:443"}},
+		{name: "ipv4 only", addr: "example.invalid:443", static: []string{"example.invalid:443:[2001:db8::1],192.0.2.1"}, network: "tcp4", want: []string{"192.0.2.1:443"}},
+		{name: "ipv6 only", addr: "example.invalid:443", static: []string{"example.invalid:443:[2001:db8::1],192.0.2.1"}, network: "tcp6", want: []string{"[2001:db8::1]:443"}},
+		{name: "no address of the family", addr: "example.invalid:443", static: []string{"example.invalid:443:192.0.2.1"}, network: "tcp6", wantErr: true},
+		{name: "ip address of the other family", addr: "127.0.0.1:80", network: "tcp6", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHostResolver(true, 0)
+			r.network = tt.network
+			var err error
+			if r.static, err = parseResolve(tt.static); err != nil {
+				t.Fatal(err)
+			}
+			if r.connectTo, err = parseConnectTo(tt.connect); err != nil {
+				t.Fatal(err)
+			}
+			// The unresolved hosts must not be looked up
+			r.cache["example.invalid"], r.cache["a.invalid"] = nil, nil
+
+			var dialed []string
+			dial := r.dialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+				dialed = append(dialed, addr)
+				return nil, &net.OpError{Op: "dial", Err: net.UnknownNetworkError("test")}
+			})
+			dial(context.Background(), "tcp", tt.addr)
+
+			if tt.wantErr {
+				if len(dialed) > 0 {
+					t.Errorf("dialed %v, want none", dialed)
+				}
+				return
+			}
+			if !reflect.DeepEqual(dialed, tt.want) {
+				t.Errorf("dialed %v, want %v", dialed, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResolve(t *testing.T) {
+	tests := []struct {
+		entries []string
+		want    map[string][]string
+		wantErr bool
+	}{
+		{entries: []string{"Example.com:443:127.0.0.1"}, want: map[string][]string{"example.com:443": {"127.0.0.1"}}},
+		{entries: []string{"example.com:443:127.0.0.1,[::1]", "example.com:443:127.0.0.2"}, want: map[string][]string{"example.com:443": {"127.0.0.1", "::1", "127.0.0.2"}}},
+		{entries: []string{"example.com:443"}, wantErr: true},
+		{entries: []string{"example.com:https:127.0.0.1"}, wantErr: true},
+		{entries: []string{"example.com:443:localhost"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseResolve(tt.entries)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseResolve(%q) error = %v, wantErr %v", tt.entries, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseResolve(%q) = %v, want %v", tt.entries, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSigning(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	_, port, _ := net.SplitHostPort(mustParseURL(t, server.URL).Host)
+
+	// The hosts don't exist, the requests get to the server only with --resolve
+	for _, host := range []string{"api.example.test", "search-test.eu-west-1.es.amazonaws.com"} {
+		_, err := runAwscurl(t, "--resolve", "other.example.test:"+port+":192.0.2.1", "--resolve", host+":"+port+":127.0.0.1",
+			"http://"+host+":"+port+"/items")
+		received := server.lastSignedRequest(t, err)
+		// The signature covers the URL host, not the resolved address
+		if received.Host != host+":"+port {
+			t.Errorf("Host = %q, want the URL host", received.Host)
+		}
+	}
+	if scope := server.lastRequest(t).Header.Get("Authorization"); !strings.Contains(scope, "/eu-west-1/es/aws4_request") {
+		t.Errorf("Authorization = %q, want the service and the region detected by the URL host", scope)
+	}
+}
+
+func TestConnectToSigning(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+
+	// The host doesn't exist, the request gets to the server only with --connect-to
+	host := "search-test.eu-west-1.es.amazonaws.com"
+	_, err := runAwscurl(t, "--connect-to", host+":80:"+mustParseURL(t, server.URL).Host, "http://"+host+"/items")
+	received := server.lastSignedRequest(t, err)
+	// The signature covers the URL host, and the service and the region are detected by it
+	if received.Host != host {
+		t.Errorf("Host = %q, want the URL host", received.Host)
+	}
+	if scope := received.Header.Get("Authorization"); !strings.Contains(scope, "/eu-west-1/es/aws4_request") {
+		t.Errorf("Authorization = %q, want the service and the region detected by the URL host", scope)
+	}
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestHTTPVersion(t *testing.T) {
+	server := &sigV4Verifier{creds: testCredentials}
+	server.Server = httptest.NewUnstartedServer(http.HandlerFunc(server.serveHTTP))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		args  []string
+		proto string
+	}{
+		// The server supports HTTP/2, but it's used only when asked for
+		{name: "default", proto: "HTTP/1.1"},
+		{name: "--http1.1", args: []string{"--http1.1"}, proto: "HTTP/1.1"},
+		{name: "--http2", args: []string{"--http2"}, proto: "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runAwscurl(t, append(tt.args, "--insecure", server.URL)...)
+			if received := server.lastSignedRequest(t, err); received.Proto != tt.proto {
+				t.Errorf("the request is sent with %s, want %s", received.Proto, tt.proto)
+			}
+		})
+	}
+}
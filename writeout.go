@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeOutVariable matches the %{name} variables of the --write-out format
+var writeOutVariable = regexp.MustCompile(`%\{([a-z_]+)\}`)
+
+// writeOutEscapes are the backslash escapes interpreted in the --write-out format
+var writeOutEscapes = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+// formatWriteOut expands the --write-out format for the completed request, the same way as curl does.
+// The times are in seconds since the start of the request, e.g. time_connect is when the connection was established.
+// Unknown variables are left as is.
+func formatWriteOut(format string, t *requestTimings, response *http.Response, sizeDownload int64) string {
+	seconds := func(to time.Time) string {
+		return strconv.FormatFloat(phase(t.start, to).Seconds(), 'f', 6, 64)
+	}
+
+	var sizeUpload int64
+	if response.Request.ContentLength > 0 {
+		sizeUpload = response.Request.ContentLength
+	}
+
+	values := map[string]string{
+		"http_code":          strconv.Itoa(response.StatusCode),
+		"response_code":      strconv.Itoa(response.StatusCode),
+		"http_version":       strings.TrimPrefix(response.Proto, "HTTP/"),
+		"content_type":       response.Header.Get("Content-Type"),
+		"url_effective":      response.Request.URL.String(),
+		"size_download":      strconv.FormatInt(sizeDownload, 10),
+		"size_upload":        strconv.FormatInt(sizeUpload, 10),
+		"time_namelookup":    seconds(t.dnsDone),
+		"time_connect":       seconds(t.connectDone),
+		"time_appconnect":    seconds(t.tlsDone),
+		"time_starttransfer": seconds(t.firstByte),
+		"time_total":         seconds(t.done),
+	}
+
+	return writeOutVariable.ReplaceAllStringFunc(writeOutEscapes.Replace(format), func(v string) string {
+		if value, ok := values[writeOutVariable.FindStringSubmatch(v)[1]]; ok {
+			return value
+		}
+		return v
+	})
+}
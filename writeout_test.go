@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatWriteOut(t *testing.T) {
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	timings := &requestTimings{
+		start:       start,
+		dnsDone:     start.Add(10 * time.Millisecond),
+		connectDone: start.Add(25 * time.Millisecond),
+		firstByte:   start.Add(1500 * time.Millisecond),
+		done:        start.Add(2 * time.Second),
+	}
+	requestURL, _ := url.Parse("https://example.execute-api.us-east-1.amazonaws.com/prod?a=1")
+	response := &http.Response{
+		StatusCode: http.StatusCreated,
+		Proto:      "HTTP/1.1",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Request:    &http.Request{URL: requestURL, ContentLength: 42},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "%{http_code}", want: "201"},
+		{format: "%{response_code} %{http_version}", want: "201 1.1"},
+		{format: "%{content_type}", want: "application/json"},
+		{format: "%{url_effective}", want: "https://example.execute-api.us-east-1.amazonaws.com/prod?a=1"},
+		{format: "%{size_download}/%{size_upload}", want: "1024/42"},
+		{format: "%{time_namelookup} %{time_connect} %{time_starttransfer} %{time_total}", want: "0.010000 0.025000 1.500000 2.000000"},
+		// The phases which didn't happen, e.g. TLS of the plain HTTP request, are zero
+		{format: "%{time_appconnect}", want: "0.000000"},
+		{format: `%{http_code}\n`, want: "201\n"},
+		{format: `a\tb\\n\r`, want: "a\tb\\n\r"},
+		{format: "%{unknown} %{HTTP_CODE} %{http_code", want: "%{unknown} %{HTTP_CODE} %{http_code"},
+		{format: "plain text", want: "plain text"},
+	}
+	for _, tt := range tests {
+		if got := formatWriteOut(tt.format, timings, response, 1024); got != tt.want {
+			t.Errorf("formatWriteOut(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestWriteOut(t *testing.T) {
+	server := newSigV4Verifier(t, testCredentials)
+	server.handler = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("accepted"))
+	}
+
+	out, err := runAwscurl(t, "--service", "execute-api", "-d", "payload", "-w", `\n%{http_code} %{content_type} %{size_download} %{size_upload} %{url_effective}`, server.URL+"/items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The metrics are printed after the body
+	lines := strings.Split(out, "\n")
+	if want := "202 text/plain 8 7 " + server.URL + "/items"; lines[len(lines)-1] != want {
+		t.Errorf("output = %q, want it to end with %q", out, want)
+	}
+}